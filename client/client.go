@@ -1,7 +1,12 @@
 package client
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
 	"net/http"
+	"sync"
 )
 
 //AuthType - Authentication type used to establish connection
@@ -13,7 +18,8 @@ const (
 	//Basic - Authenticate with standard RFC2617 mechanism
 	Basic AuthType = "BASIC"
 	/*Cookie - Authenticate the user by sending the POST request to /_session endpoint with a name and password in the body of the request.
-	If successful then the returned cookie will be attached to the context.
+	If successful then the returned cookie will be attached to the context. When the session expires, the request will
+	re-authenticate with the stored credentials and the failed request will be sent once again.
 	*/
 	Cookie AuthType = "COOKIE"
 	/*JwtToken - As refrecne says: "Enables CouchDB to use externally generated tokens instead of defining users or roles..."
@@ -22,8 +28,49 @@ const (
 	authentication_handlers = {chttpd_auth, cookie_authentication_handler}, {chttpd_auth, jwt_authentication_handler}, {chttpd_auth, default_authentication_handler}
 	*/
 	JwtToken AuthType = "JWT"
+	/*Proxy - Identity of a user authenticated by an external system is forwarded in X-Auth-CouchDB-* headers.
+		Make sure that proxy authentication handler is enabled in the CouchDB instance. If the CouchDB requires a token
+		(proxy_use_secret = true), then the secret must be the same as the one in the [chttpd_auth] section.
+		[chttpd]
+	authentication_handlers = {chttpd_auth, proxy_authentication_handler}, {chttpd_auth, default_authentication_handler}
+	*/
+	Proxy AuthType = "PROXY"
+)
+
+//Headers used by the proxy authentication
+const (
+	ProxyUserNameHeader = "X-Auth-CouchDB-UserName"
+	ProxyRolesHeader    = "X-Auth-CouchDB-Roles"
+	ProxyTokenHeader    = "X-Auth-CouchDB-Token"
 )
 
+type proxyUserKey struct{}
+
+//ProxyUser - Identity of a user forwarded to CouchDB with the Proxy authentication
+type ProxyUser struct {
+	Name  string
+	Roles []string
+}
+
+/*WithProxyUser - Returns a copy of the context with a user that overrides the default one set in the connection.
+Requests executed with this context will act on behalf of the given user.
+*/
+func WithProxyUser(ctx context.Context, name string, roles ...string) context.Context {
+	return context.WithValue(ctx, proxyUserKey{}, ProxyUser{Name: name, Roles: roles})
+}
+
+//ProxyUserFromContext - Returns a user stored in the context by WithProxyUser
+func ProxyUserFromContext(ctx context.Context) (ProxyUser, bool) {
+	if ctx == nil {
+		return ProxyUser{}, false
+	}
+	u, ok := ctx.Value(proxyUserKey{}).(ProxyUser)
+	return u, ok
+}
+
+//SessionCookieName - Name of the cookie returned by the _session endpoint
+const SessionCookieName = "AuthSession"
+
 //CouchClient - Holds connection data
 type CouchClient struct {
 	BaseAddr       string
@@ -31,4 +78,98 @@ type CouchClient struct {
 	Authentication AuthType
 	AuthData       string
 	Client         *http.Client
+
+	mu       sync.RWMutex
+	renew    sync.Mutex
+	username string
+	password string
+
+	proxyUser   ProxyUser
+	proxySecret string
+
+	tokens  *cachedToken
+	retry   RetryPolicy
+	nodes   *NodePool
+	warning WarningPolicy
+
+	middleware []Middleware
+}
+
+//GetAuthData - Returns current authentication data, for a Cookie authentication it is the value of a session cookie
+func (c *CouchClient) GetAuthData() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.AuthData
+}
+
+//SetAuthData - Replaces current authentication data
+func (c *CouchClient) SetAuthData(data string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.AuthData = data
+}
+
+//SetCredentials - Stores credentials used to renew an expired session
+func (c *CouchClient) SetCredentials(username, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.username = username
+	c.password = password
+}
+
+//Credentials - Returns credentials used to renew an expired session
+func (c *CouchClient) Credentials() (string, string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.username, c.password
+}
+
+/*LockRenewal - Serializes session renewal, so when many requests fail at the same time
+only the first one of them will establish a new session
+*/
+func (c *CouchClient) LockRenewal() {
+	c.renew.Lock()
+}
+
+//UnlockRenewal - Releases the lock acquired by LockRenewal
+func (c *CouchClient) UnlockRenewal() {
+	c.renew.Unlock()
+}
+
+//SetProxyUser - Sets a default user and a secret used by the Proxy authentication. The secret may be empty if CouchDB does not require a token
+func (c *CouchClient) SetProxyUser(user ProxyUser, secret string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.proxyUser = user
+	c.proxySecret = secret
+}
+
+/*ProxyUser - Returns a user that will be forwarded to CouchDB, if the context contains a user then it takes precedence
+over the default one.
+*/
+func (c *CouchClient) ProxyUser(ctx context.Context) ProxyUser {
+
+	if u, ok := ProxyUserFromContext(ctx); ok {
+		return u
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.proxyUser
+}
+
+//ProxyToken - Computes a token for the given user name, returns an empty string if the secret is not set
+func (c *CouchClient) ProxyToken(name string) string {
+
+	c.mu.RLock()
+	secret := c.proxySecret
+	c.mu.RUnlock()
+
+	if secret == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))
 }
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"net/http"
+
+	"github.com/przebro/couchdb/response"
+)
+
+//Handler - Executes a final http request and returns a response
+type Handler func(rq *http.Request) (response.CouchResponse, error)
+
+/*Middleware - Wraps a handler, it can inspect or modify a request before it is passed to the next handler
+and a response returned from it. Middleware is invoked for every attempt of a request, including repeated ones.
+*/
+type Middleware func(next Handler) Handler
+
+//Use - Appends middleware to the chain, the first registered middleware is the outermost one
+func (c *CouchClient) Use(mw ...Middleware) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.middleware = append(c.middleware, mw...)
+}
+
+//Chain - Wraps the handler with registered middleware
+func (c *CouchClient) Chain(h Handler) Handler {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		h = c.middleware[i](h)
+	}
+
+	return h
+}
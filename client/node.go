@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+//DefaultNodeCooldown - a time after which an unhealthy node receives a request again to check if it has recovered
+const DefaultNodeCooldown = 30 * time.Second
+
+type nodeKey struct{}
+
+//Node - Represents a single node of a cluster
+type Node struct {
+	//Addr - base address of the node, including a scheme and a prefix
+	Addr    string
+	healthy int32
+	down    int64
+}
+
+//NewNode - Creates a new node, initially the node is considered healthy
+func NewNode(addr string) *Node {
+	return &Node{Addr: addr, healthy: 1}
+}
+
+//Healthy - Checks if the node is considered healthy
+func (n *Node) Healthy() bool {
+	return atomic.LoadInt32(&n.healthy) == 1
+}
+
+//SetHealthy - Marks the node as healthy or unhealthy
+func (n *Node) SetHealthy(healthy bool) {
+	var v int32
+	var down int64
+	if healthy {
+		v = 1
+	} else {
+		down = time.Now().UnixNano()
+	}
+	atomic.StoreInt64(&n.down, down)
+	atomic.StoreInt32(&n.healthy, v)
+}
+
+//recovering - checks if the node is unhealthy for longer than the cooldown, so it may be tried again
+func (n *Node) recovering(cooldown time.Duration) bool {
+	down := atomic.LoadInt64(&n.down)
+	return !n.Healthy() && down > 0 && time.Since(time.Unix(0, down)) >= cooldown
+}
+
+//NodeSelector - Chooses a node that will receive a request
+type NodeSelector interface {
+	//Select - chooses one of candidates, candidates are never empty and are given in the configured order
+	Select(candidates []*Node) *Node
+}
+
+//RoundRobin - Spreads requests evenly across nodes
+type RoundRobin struct {
+	counter uint32
+}
+
+//Select - Chooses the next node
+func (r *RoundRobin) Select(candidates []*Node) *Node {
+	n := atomic.AddUint32(&r.counter, 1) - 1
+	return candidates[int(n%uint32(len(candidates)))]
+}
+
+//PrimaryWithFallback - Sends requests to the first node, other nodes are used only when the preceding ones are not available
+type PrimaryWithFallback struct{}
+
+//Select - Chooses the first node
+func (PrimaryWithFallback) Select(candidates []*Node) *Node {
+	return candidates[0]
+}
+
+//NodePool - Holds nodes of a cluster
+type NodePool struct {
+	nodes    []*Node
+	selector NodeSelector
+	cooldown time.Duration
+}
+
+/*NewNodePool - Creates a new pool with given nodes, if the selector is nil, then the PrimaryWithFallback is used.
+A request is sent to the next node when the current one is not reachable or it responds with a status that the retry policy
+allows to retry. If the pool has no nodes, then requests are sent to the BaseAddr. An unhealthy node is tried again
+after the DefaultNodeCooldown and any response marks it as healthy, so nodes recover even without the health check.
+*/
+func NewNodePool(selector NodeSelector, nodes ...*Node) *NodePool {
+
+	if selector == nil {
+		selector = PrimaryWithFallback{}
+	}
+
+	return &NodePool{nodes: nodes, selector: selector, cooldown: DefaultNodeCooldown}
+}
+
+//SetCooldown - Sets a time after which an unhealthy node is tried again, it should be set before the pool is used
+func (p *NodePool) SetCooldown(cooldown time.Duration) {
+	p.cooldown = cooldown
+}
+
+//Nodes - Returns all nodes
+func (p *NodePool) Nodes() []*Node {
+	return p.nodes
+}
+
+/*Next - Chooses a node for the next attempt, nodes that were already tried are skipped. Unhealthy nodes are used only
+if there are no healthy ones left, unless they are unhealthy for longer than the cooldown. Returns nil if all nodes were tried.
+*/
+func (p *NodePool) Next(tried map[*Node]bool) *Node {
+
+	healthy := []*Node{}
+	unhealthy := []*Node{}
+
+	for _, n := range p.nodes {
+		if tried[n] {
+			continue
+		}
+		if n.Healthy() || n.recovering(p.cooldown) {
+			healthy = append(healthy, n)
+		} else {
+			unhealthy = append(unhealthy, n)
+		}
+	}
+
+	if len(healthy) > 0 {
+		return p.selector.Select(healthy)
+	}
+
+	if len(unhealthy) > 0 {
+		return p.selector.Select(unhealthy)
+	}
+
+	return nil
+}
+
+//SetNodes - Sets nodes of a cluster, requests will be routed to these nodes instead of the BaseAddr
+func (c *CouchClient) SetNodes(pool *NodePool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nodes = pool
+}
+
+//Nodes - Returns nodes of a cluster or nil if the client uses only the BaseAddr
+func (c *CouchClient) Nodes() *NodePool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.nodes
+}
+
+//WithNode - Returns a copy of the context that pins requests to the given node, so there is no node selection and failover
+func WithNode(ctx context.Context, node *Node) context.Context {
+	return context.WithValue(ctx, nodeKey{}, node)
+}
+
+//NodeFromContext - Returns a node stored in the context by WithNode
+func NodeFromContext(ctx context.Context) (*Node, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	n, ok := ctx.Value(nodeKey{}).(*Node)
+	return n, ok && n != nil
+}
+
+//IsIdempotent - Checks if a request with the method may be safely sent again
+func IsIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
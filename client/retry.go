@@ -0,0 +1,121 @@
+package client
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+//Default values of a retry policy
+const (
+	DefaultMaxAttempts = 3
+	DefaultMinBackoff  = 100 * time.Millisecond
+	DefaultMaxBackoff  = 5 * time.Second
+)
+
+/*RetryPolicy - Describes when and how a failed request is repeated. A request is repeated if it failed with a network error
+or the server responded with one of the retryable status codes. Only idempotent requests are repeated, unless RetryNonIdempotent is set,
+a request may also be marked as idempotent explicitly e.g. _find. A zero value policy makes exactly one attempt.
+*/
+type RetryPolicy struct {
+	//MaxAttempts - the maximum number of attempts including the first one
+	MaxAttempts int
+	//MinBackoff - a delay before the second attempt, every next delay is doubled
+	MinBackoff time.Duration
+	//MaxBackoff - the maximum delay between attempts
+	MaxBackoff time.Duration
+	//StatusCodes - response codes that are considered transient
+	StatusCodes []int
+	//Methods - methods that are considered idempotent, if empty, then GET, HEAD, PUT and DELETE are used
+	Methods []string
+	//RetryNonIdempotent - allows to repeat requests regardless of the method
+	RetryNonIdempotent bool
+}
+
+//DefaultRetryPolicy - Returns a policy that repeats idempotent requests three times on 429, 500, 502, 503 and 504
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: DefaultMaxAttempts,
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		StatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		Methods: []string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete},
+	}
+}
+
+//RetryableStatus - Checks if a response with the code may be repeated
+func (p RetryPolicy) RetryableStatus(code int) bool {
+	for _, c := range p.StatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+//RetryableMethod - Checks if a request with the method may be repeated
+func (p RetryPolicy) RetryableMethod(method string) bool {
+
+	if p.RetryNonIdempotent {
+		return true
+	}
+
+	if len(p.Methods) == 0 {
+		return IsIdempotent(method)
+	}
+
+	for _, m := range p.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+/*Backoff - Returns a delay before the next attempt, the attempt is a number of the failed attempt starting from 1.
+The delay grows exponentially and a random jitter is applied, so a half of the delay is fixed and the other half is random.
+*/
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+
+	min := p.MinBackoff
+	if min <= 0 {
+		min = DefaultMinBackoff
+	}
+
+	max := p.MaxBackoff
+	if max < min {
+		max = min
+	}
+
+	delay := min
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+
+	if delay > max {
+		delay = max
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+//SetRetryPolicy - Sets a policy used to repeat failed requests
+func (c *CouchClient) SetRetryPolicy(policy RetryPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retry = policy
+}
+
+//RetryPolicy - Returns a policy used to repeat failed requests
+func (c *CouchClient) RetryPolicy() RetryPolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.retry
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+//TokenRefreshMargin - A token is refreshed when it expires within this margin
+const TokenRefreshMargin = 30 * time.Second
+
+var errEmptyToken = errors.New("token source returned an empty token")
+
+/*TokenSource - Provides tokens for the JwtToken authentication. The expiry is used to decide when a token should be refreshed,
+a zero value means that the token never expires.
+*/
+type TokenSource interface {
+	Token(ctx context.Context) (string, time.Time, error)
+}
+
+//TokenSourceFunc - An adapter to allow the use of an ordinary function as a TokenSource
+type TokenSourceFunc func(ctx context.Context) (string, time.Time, error)
+
+//Token - calls f(ctx)
+func (f TokenSourceFunc) Token(ctx context.Context) (string, time.Time, error) {
+	return f(ctx)
+}
+
+//cachedToken - Caches a token until it is near expiry
+type cachedToken struct {
+	source  TokenSource
+	mu      sync.Mutex
+	token   string
+	expiry  time.Time
+	refresh sync.Mutex
+}
+
+func (c *cachedToken) valid() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == "" {
+		return "", false
+	}
+	if !c.expiry.IsZero() && time.Now().Add(TokenRefreshMargin).After(c.expiry) {
+		return "", false
+	}
+	return c.token, true
+}
+
+func (c *cachedToken) get(ctx context.Context) (string, error) {
+
+	if token, ok := c.valid(); ok {
+		return token, nil
+	}
+
+	//only one goroutine refreshes a token, others wait and use the refreshed one
+	c.refresh.Lock()
+	defer c.refresh.Unlock()
+
+	if token, ok := c.valid(); ok {
+		return token, nil
+	}
+
+	token, expiry, err := c.source.Token(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if token == "" {
+		return "", errEmptyToken
+	}
+
+	c.mu.Lock()
+	c.token = token
+	c.expiry = expiry
+	c.mu.Unlock()
+
+	return token, nil
+}
+
+func (c *cachedToken) invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+//SetTokenSource - Sets a source of tokens used by the JwtToken authentication instead of a static token
+func (c *CouchClient) SetTokenSource(source TokenSource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if source == nil {
+		c.tokens = nil
+		return
+	}
+	c.tokens = &cachedToken{source: source}
+}
+
+/*Token - Returns a token used by the JwtToken authentication. If a token source is set, then the token is taken from the cache
+or requested from the source when it is about to expire; otherwise the static token is returned.
+*/
+func (c *CouchClient) Token(ctx context.Context) (string, error) {
+
+	c.mu.RLock()
+	tokens := c.tokens
+	c.mu.RUnlock()
+
+	if tokens == nil {
+		return c.GetAuthData(), nil
+	}
+
+	return tokens.get(ctx)
+}
+
+/*InvalidateToken - Removes a token rejected by the server from the cache, the next call to Token will request a new one.
+It returns false if the token cannot be refreshed because a static token is used.
+*/
+func (c *CouchClient) InvalidateToken(token string) bool {
+
+	c.mu.RLock()
+	tokens := c.tokens
+	c.mu.RUnlock()
+
+	if tokens == nil {
+		return false
+	}
+
+	tokens.invalidate(token)
+	return true
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+)
+
+//WarningMode - Describes what happens when a Mango query returns a warning
+type WarningMode int
+
+//Modes of a warning policy
+const (
+	//WarningIgnore - warnings are only available in the meta of a cursor
+	WarningIgnore WarningMode = iota
+	//WarningLog - warnings are passed to the hook of a policy
+	WarningLog
+	//WarningError - a query that returns a warning fails with an error
+	WarningError
+	//WarningCreateIndex - a json index for fields of the selector is created when no matching index was found
+	WarningCreateIndex
+)
+
+//QueryWarning - Describes a warning returned by a query
+type QueryWarning struct {
+	Database string
+	Selector json.RawMessage
+	Warning  string
+	//Index - a name of an index created by the WarningCreateIndex mode
+	Index string
+	//Err - an error that occurred while the index was created
+	Err error
+}
+
+//WarningHook - A function that receives warnings of queries
+type WarningHook func(ctx context.Context, warning QueryWarning)
+
+/*WarningPolicy - Describes how warnings returned by Mango queries are handled, e.g. a query executed without an index.
+The Hook is called in every mode except WarningIgnore, if it is set.
+*/
+type WarningPolicy struct {
+	Mode WarningMode
+	Hook WarningHook
+}
+
+//SetWarningPolicy - Sets a policy used by all databases of the client, unless a database has its own policy
+func (c *CouchClient) SetWarningPolicy(policy WarningPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.warning = policy
+}
+
+//WarningPolicy - Returns a policy of warnings of queries
+func (c *CouchClient) WarningPolicy() WarningPolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.warning
+}
@@ -3,12 +3,13 @@ package connection
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,6 +25,12 @@ const (
 	httpsScheme connectionScheme = "https"
 )
 
+const (
+	defaultDialTimeout  = 30 * time.Second
+	defaultKeepAlive    = 30 * time.Second
+	defaultMaxIdleConns = 100
+)
+
 type builder struct {
 	cert      bool
 	host      string
@@ -32,21 +39,72 @@ type builder struct {
 	authData  string
 	sesToken  string
 	jwtToken  string
+	tokens    client.TokenSource
 	usernmame string
 	password  string
+	proxyUser client.ProxyUser
+	secret    string
 
 	skipVerify bool
 	caPath     string
 	keypath    string
 	certpath   string
+
+	secure  bool
+	prefix  string
+	timeout time.Duration
+
+	caPEM       []byte
+	keyPEM      []byte
+	certPEM     []byte
+	tlsConfig   *tls.Config
+	systemRoots bool
+	reload      bool
+
+	httpClient     *http.Client
+	transport      http.RoundTripper
+	maxIdle        int
+	maxIdlePerHost int
+	maxPerHost     int
+	dialTimeout    time.Duration
+	headerTimeout  time.Duration
+	proxy          string
+	retry          client.RetryPolicy
+	warning        client.WarningPolicy
+
+	nodes          []string
+	selector       client.NodeSelector
+	healthInterval time.Duration
+
+	middleware []client.Middleware
 }
 
 //ConnectionBuilder - Builds a connection with database
 type ConnectionBuilder interface {
 	WithAddress(address string, port int) ConnectionBuilder
 	WithCertificate(rootca, clientkey, cert string, skipVerify bool) ConnectionBuilder
+	WithPEMCertificate(rootca, clientkey, cert []byte, skipVerify bool) ConnectionBuilder
+	WithTLSConfig(cfg *tls.Config) ConnectionBuilder
+	WithSystemCertPool(augment bool) ConnectionBuilder
+	WithClientCertificateReload(clientkey, cert string) ConnectionBuilder
 	WithToken(token string) ConnectionBuilder
+	WithTokenSource(source client.TokenSource) ConnectionBuilder
 	WithAuthentication(atype client.AuthType, username, password string) ConnectionBuilder
+	WithProxyAuthentication(username string, roles []string, secret string) ConnectionBuilder
+	WithPrefix(prefix string) ConnectionBuilder
+	WithTimeout(timeout time.Duration) ConnectionBuilder
+	WithHTTPClient(cli *http.Client) ConnectionBuilder
+	WithTransport(transport http.RoundTripper) ConnectionBuilder
+	WithPoolLimits(maxIdle, maxIdlePerHost, maxPerHost int) ConnectionBuilder
+	WithTransportTimeouts(dial, responseHeader time.Duration) ConnectionBuilder
+	WithProxy(proxyURL string) ConnectionBuilder
+	WithRetryPolicy(policy client.RetryPolicy) ConnectionBuilder
+	WithWarningPolicy(policy client.WarningPolicy) ConnectionBuilder
+	WithNodes(addresses ...string) ConnectionBuilder
+	WithNodeSelector(selector client.NodeSelector) ConnectionBuilder
+	WithHealthCheck(interval time.Duration) ConnectionBuilder
+	WithMiddleware(mw ...client.Middleware) ConnectionBuilder
+	String() string
 	Build(connect bool) (*Connection, error)
 }
 
@@ -71,6 +129,9 @@ func (b *builder) WithAuthentication(atype client.AuthType, username, password s
 		return b
 	}
 
+	b.usernmame = username
+	b.password = password
+
 	if atype == client.Basic {
 		data := fmt.Sprintf("%s:%s", username, password)
 		b.authData = base64.StdEncoding.EncodeToString([]byte(data))
@@ -87,34 +148,192 @@ func (b *builder) WithCertificate(rootca, clientkey, clinetCert string, skipVeri
 	b.keypath = clientkey
 	b.certpath = clinetCert
 	b.skipVerify = skipVerify
+	b.caPEM, b.keyPEM, b.certPEM = nil, nil, nil
+	b.reload = false
+	return b
+}
+
+//WithPEMCertificate - Works like WithCertificate, but certificates and a key are PEM encoded data instead of paths
+func (b *builder) WithPEMCertificate(rootca, clientkey, clinetCert []byte, skipVerify bool) ConnectionBuilder {
+
+	b.secure = true
+	b.cert = false
+	b.caPEM = rootca
+	b.keyPEM = clientkey
+	b.certPEM = clinetCert
+	b.skipVerify = skipVerify
+	b.caPath, b.keypath, b.certpath = "", "", ""
+	b.reload = false
+	return b
+}
+
+/*WithTLSConfig - Sets a base TLS configuration e.g. with MinVersion, ServerName or CipherSuites. The configuration is cloned
+and certificates provided by other methods are added to the clone.
+*/
+func (b *builder) WithTLSConfig(cfg *tls.Config) ConnectionBuilder {
+	b.secure = true
+	b.tlsConfig = cfg
+	return b
+}
+
+/*WithSystemCertPool - If augment is true, then a root certificate authority is added to the system pool,
+otherwise it is the only trusted authority.
+*/
+func (b *builder) WithSystemCertPool(augment bool) ConnectionBuilder {
+	b.systemRoots = augment
+	return b
+}
+
+/*WithClientCertificateReload - Sets paths to a client key and a client certificate that are loaded again
+when files change on disk, so rotated certificates are used without rebuilding a connection.
+*/
+func (b *builder) WithClientCertificateReload(clientkey, clinetCert string) ConnectionBuilder {
+	b.secure = true
+	b.keypath = clientkey
+	b.certpath = clinetCert
+	b.keyPEM, b.certPEM = nil, nil
+	b.reload = true
 	return b
 }
 func (b *builder) WithToken(token string) ConnectionBuilder {
 	b.jwtToken = token
+	b.tokens = nil
 	b.auth = client.JwtToken
 	return b
 }
 
+/*WithTokenSource - Sets a JwtToken authentication with tokens provided by the source. Tokens are cached and refreshed
+shortly before they expire or when the server rejects them.
+*/
+func (b *builder) WithTokenSource(source client.TokenSource) ConnectionBuilder {
+	b.tokens = source
+	b.jwtToken = ""
+	b.auth = client.JwtToken
+	return b
+}
+
+/*WithProxyAuthentication - Sets the Proxy authentication, the username and roles are forwarded to CouchDB as a default identity,
+it can be overridden for a single request with client.WithProxyUser. The secret is used to compute the X-Auth-CouchDB-Token header,
+leave it empty if CouchDB does not require a token.
+*/
+func (b *builder) WithProxyAuthentication(username string, roles []string, secret string) ConnectionBuilder {
+	b.auth = client.Proxy
+	b.proxyUser = client.ProxyUser{Name: username, Roles: roles}
+	b.secret = secret
+	return b
+}
+
+//WithPrefix - Sets a path prefix, useful when CouchDB is available behind a reverse proxy under a path other than root
+func (b *builder) WithPrefix(prefix string) ConnectionBuilder {
+	b.prefix = strings.Trim(prefix, "/")
+	return b
+}
+
+/*WithTimeout - Sets a time limit for waiting for response headers, unless it is set by WithTransportTimeouts.
+Reading of the response body is not limited, because changes feeds and attachments are streamed for a long time,
+use a deadline of the context to limit a whole call. Zero means no timeout
+*/
+func (b *builder) WithTimeout(timeout time.Duration) ConnectionBuilder {
+	b.timeout = timeout
+	return b
+}
+
+/*WithHTTPClient - Sets a custom http client, the client is used as it is, so the timeout, TLS, pool and proxy settings
+of the builder are ignored
+*/
+func (b *builder) WithHTTPClient(cli *http.Client) ConnectionBuilder {
+	b.httpClient = cli
+	return b
+}
+
+//WithTransport - Sets a custom transport, TLS, pool, proxy and timeout settings of the builder are ignored
+func (b *builder) WithTransport(transport http.RoundTripper) ConnectionBuilder {
+	b.transport = transport
+	return b
+}
+
+/*WithPoolLimits - Sets limits of the connection pool: the maximum number of idle connections, idle connections per host
+and all connections per host. Zero means a default value
+*/
+func (b *builder) WithPoolLimits(maxIdle, maxIdlePerHost, maxPerHost int) ConnectionBuilder {
+	b.maxIdle = maxIdle
+	b.maxIdlePerHost = maxIdlePerHost
+	b.maxPerHost = maxPerHost
+	return b
+}
+
+//WithTransportTimeouts - Sets a time limit for establishing a connection and for waiting for response headers. Zero means a default value
+func (b *builder) WithTransportTimeouts(dial, responseHeader time.Duration) ConnectionBuilder {
+	b.dialTimeout = dial
+	b.headerTimeout = responseHeader
+	return b
+}
+
+//WithProxy - Sets a http proxy, by default a proxy is taken from the environment variables
+func (b *builder) WithProxy(proxyURL string) ConnectionBuilder {
+	b.proxy = proxyURL
+	return b
+}
+
+//WithRetryPolicy - Sets a policy used to repeat requests that failed with a transient error, see client.DefaultRetryPolicy
+func (b *builder) WithRetryPolicy(policy client.RetryPolicy) ConnectionBuilder {
+	b.retry = policy
+	return b
+}
+
+//WithWarningPolicy - Sets a policy of warnings returned by Mango queries e.g. when a query does not use an index
+func (b *builder) WithWarningPolicy(policy client.WarningPolicy) ConnectionBuilder {
+	b.warning = policy
+	return b
+}
+
+/*WithNodes - Adds nodes of a cluster in the form host:port. A node set by WithAddress is the first one,
+other nodes share the scheme, the prefix and the authentication with it.
+*/
+func (b *builder) WithNodes(addresses ...string) ConnectionBuilder {
+	b.nodes = addresses
+	return b
+}
+
+//WithNodeSelector - Sets a strategy of choosing a node, by default client.PrimaryWithFallback is used
+func (b *builder) WithNodeSelector(selector client.NodeSelector) ConnectionBuilder {
+	b.selector = selector
+	return b
+}
+
+/*WithHealthCheck - Enables a background check of cluster nodes with the _up endpoint, unhealthy nodes are skipped
+until they are up again. The check is stopped by Connection.Close
+*/
+func (b *builder) WithHealthCheck(interval time.Duration) ConnectionBuilder {
+	b.healthInterval = interval
+	return b
+}
+
+//WithMiddleware - Adds middleware that wraps every request executed by the connection, see client.Middleware
+func (b *builder) WithMiddleware(mw ...client.Middleware) ConnectionBuilder {
+	b.middleware = append(b.middleware, mw...)
+	return b
+}
+
 /*Build - Set up and build connections additionally if flag connect is set to true then invoke an authorization method
 or simply call  up endpoint to check if connection is set up properly
 */
 func (b *builder) Build(connect bool) (*Connection, error) {
 
 	scheme := func() connectionScheme {
-		if b.cert {
+		if b.cert || b.secure {
 			return httpsScheme
 		}
 		return httpScheme
 
 	}()
 
-	addr := func() string {
-		if b.auth == client.None {
-			return fmt.Sprintf(`%s://%s@%s:%d`, scheme, b.authData, b.host, b.port)
-		}
-		return fmt.Sprintf(`%s://%s:%d`, scheme, b.host, b.port)
+	addr := b.address(scheme, b.host, b.port)
 
-	}()
+	pool, err := b.buildNodePool(scheme, addr)
+	if err != nil {
+		return nil, err
+	}
 
 	if b.auth == client.Cookie && b.authData == "" {
 		return nil, errors.New("no authentication data")
@@ -125,26 +344,42 @@ func (b *builder) Build(connect bool) (*Connection, error) {
 		authData = b.authData
 	}
 
-	var tran *http.Transport = nil
+	if b.auth == client.JwtToken && b.jwtToken != "" {
+		authData = b.jwtToken
+	}
 
-	if b.cert {
-		var er error
-		tran, er = b.buildSecureTransport()
-		if er != nil {
-			return nil, er
-		}
-	} else {
-		tran = http.DefaultTransport.(*http.Transport)
+	httpClient, err := b.buildClient()
+	if err != nil {
+		return nil, err
 	}
 
-	cli := &client.CouchClient{Client: &http.Client{Transport: tran},
+	cli := &client.CouchClient{Client: httpClient,
 		BaseAddr:       addr,
 		Authentication: b.auth,
 		AuthData:       authData,
 	}
 	conn := &Connection{cli: cli}
 
-	var err error
+	cli.SetRetryPolicy(b.retry)
+	cli.SetWarningPolicy(b.warning)
+	cli.Use(b.middleware...)
+
+	if pool != nil {
+		cli.SetNodes(pool)
+	}
+
+	if b.auth == client.Cookie {
+		userpass := strings.SplitN(b.authData, ":", 2)
+		cli.SetCredentials(userpass[0], userpass[1])
+	}
+
+	if b.auth == client.Proxy {
+		cli.SetProxyUser(b.proxyUser, b.secret)
+	}
+
+	if b.auth == client.JwtToken && b.tokens != nil {
+		cli.SetTokenSource(b.tokens)
+	}
 
 	if connect {
 
@@ -152,8 +387,8 @@ func (b *builder) Build(connect bool) (*Connection, error) {
 		var err error
 		if b.auth == client.Cookie {
 
-			userpass := strings.SplitN(b.authData, ":", 2)
-			res, err = conn.Session(context.TODO(), userpass[0], userpass[1])
+			username, password := cli.Credentials()
+			res, err = conn.Session(context.TODO(), username, password)
 
 		} else {
 			res, err = conn.Up(context.TODO())
@@ -163,60 +398,130 @@ func (b *builder) Build(connect bool) (*Connection, error) {
 			return conn, err
 		}
 
-		if res.Code >= 400 {
-			return conn, errors.New(res.Status)
+		res.Close()
+	}
+
+	if pool != nil && b.healthInterval > 0 {
+		conn.startHealthCheck(b.healthInterval)
+	}
+
+	return conn, nil
+}
+
+//address - Builds a base address of a node
+func (b *builder) address(scheme connectionScheme, host string, port int) string {
+
+	addr := func() string {
+		if b.auth == client.None {
+			return fmt.Sprintf(`%s://%s@%s:%d`, scheme, b.authData, host, port)
 		}
+		return fmt.Sprintf(`%s://%s:%d`, scheme, host, port)
+
+	}()
 
+	if b.prefix != "" {
+		addr = fmt.Sprintf(`%s/%s`, addr, b.prefix)
 	}
 
-	return conn, err
+	return addr
 }
 
-//buildSecureTransport - Builds new Transport with provided certificates
-func (b *builder) buildSecureTransport() (*http.Transport, error) {
+//buildNodePool - Builds a pool of cluster nodes, the primary address is the first node. Returns nil if there are no additional nodes
+func (b *builder) buildNodePool(scheme connectionScheme, primary string) (*client.NodePool, error) {
 
-	var certs []tls.Certificate = nil
+	if len(b.nodes) == 0 {
+		return nil, nil
+	}
 
-	/*
-		check if both client key and client certificate are provided if not,
-		continue, however, a server may be configured to require a client's certificate and reject a connection without a client's certificate
-	*/
-	if b.certpath != "" && b.keypath != "" {
+	nodes := []*client.Node{}
+	if b.host != "" {
+		nodes = append(nodes, client.NewNode(primary))
+	}
 
-		cert, err := tls.LoadX509KeyPair(b.certpath, b.keypath)
+	for _, n := range b.nodes {
+
+		host, p, err := net.SplitHostPort(n)
 		if err != nil {
 			return nil, err
 		}
 
-		certs = []tls.Certificate{cert}
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %s", p)
+		}
+
+		nodes = append(nodes, client.NewNode(b.address(scheme, host, port)))
 	}
 
-	data, err := ioutil.ReadFile(b.caPath)
-	if err != nil {
-		return nil, err
+	return client.NewNodePool(b.selector, nodes...), nil
+}
+
+//buildClient - Builds http client, unless a custom client or transport is provided, the connection owns a dedicated transport
+func (b *builder) buildClient() (*http.Client, error) {
+
+	if b.httpClient != nil {
+		return b.httpClient, nil
 	}
 
-	pool := x509.NewCertPool()
+	tran := b.transport
 
-	pool.AppendCertsFromPEM(data)
+	if tran == nil {
+		var err error
+		if tran, err = b.buildTransport(); err != nil {
+			return nil, err
+		}
+	}
 
-	tlscfg := &tls.Config{
-		RootCAs:            pool,
-		InsecureSkipVerify: b.skipVerify,
+	return &http.Client{Transport: tran}, nil
+}
+
+//buildTransport - Builds new Transport with configured limits, timeouts and a proxy
+func (b *builder) buildTransport() (*http.Transport, error) {
+
+	dialTimeout := defaultDialTimeout
+	if b.dialTimeout > 0 {
+		dialTimeout = b.dialTimeout
+	}
+
+	maxIdle := defaultMaxIdleConns
+	if b.maxIdle > 0 {
+		maxIdle = b.maxIdle
 	}
-	if certs != nil {
-		tlscfg.Certificates = certs
+
+	proxy := http.ProxyFromEnvironment
+	if b.proxy != "" {
+		u, err := url.Parse(b.proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	headerTimeout := b.timeout
+	if b.headerTimeout > 0 {
+		headerTimeout = b.headerTimeout
 	}
 
 	tr := &http.Transport{
-		TLSClientConfig:       tlscfg,
+		Proxy:                 proxy,
+		DialContext:           (&net.Dialer{Timeout: dialTimeout, KeepAlive: defaultKeepAlive}).DialContext,
 		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          100,
+		MaxIdleConns:          maxIdle,
+		MaxIdleConnsPerHost:   b.maxIdlePerHost,
+		MaxConnsPerHost:       b.maxPerHost,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
+		ResponseHeaderTimeout: headerTimeout,
 	}
 
-	return tr, nil
+	if b.cert || b.secure {
+		tlscfg, err := b.buildTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		tr.TLSClientConfig = tlscfg
+	}
 
+	return tr, nil
 }
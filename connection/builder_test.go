@@ -3,9 +3,11 @@ package connection
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/przebro/couchdb/client"
 )
@@ -78,3 +80,70 @@ func TestSecureConConn(t *testing.T) {
 	}
 
 }
+
+func TestBuildTransport(t *testing.T) {
+
+	conn, err := NewBuilder().WithAddress(host, port).
+		WithPoolLimits(10, 5, 20).
+		WithTransportTimeouts(time.Second, 2*time.Second).
+		WithProxy("http://proxy.local:3128").
+		WithTimeout(5 * time.Second).
+		Build(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the timeout must not cut off streamed bodies, so it is not set on the client
+	cli := conn.GetClient().Client
+	if cli.Timeout != 0 {
+		t.Error("unexpected result:", cli.Timeout)
+	}
+
+	tran, ok := cli.Transport.(*http.Transport)
+	if !ok || tran == http.DefaultTransport {
+		t.Fatal("unexpected result")
+	}
+
+	if tran.MaxIdleConns != 10 || tran.MaxIdleConnsPerHost != 5 || tran.MaxConnsPerHost != 20 || tran.ResponseHeaderTimeout != 2*time.Second {
+		t.Error("unexpected result")
+	}
+
+	rq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:5300", nil)
+	proxy, err := tran.Proxy(rq)
+	if err != nil || proxy == nil || proxy.Host != "proxy.local:3128" {
+		t.Error("unexpected result:", proxy, err)
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Error(err)
+	}
+
+	conn, err = NewBuilder().WithAddress(host, port).WithTimeout(5 * time.Second).Build(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tran = conn.GetClient().Client.Transport.(*http.Transport); tran.ResponseHeaderTimeout != 5*time.Second {
+		t.Error("unexpected result:", tran.ResponseHeaderTimeout)
+	}
+	conn.Close()
+
+	custom := &http.Client{}
+	conn, err = NewBuilder().WithAddress(host, port).WithHTTPClient(custom).Build(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conn.GetClient().Client != custom {
+		t.Error("unexpected result")
+	}
+
+	conn, err = NewBuilder().WithAddress(host, port).WithTransport(http.DefaultTransport).Build(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conn.GetClient().Client.Transport != http.DefaultTransport {
+		t.Error("unexpected result")
+	}
+}
@@ -21,7 +21,8 @@ const (
 
 //Connection - Represents server connection
 type Connection struct {
-	cli *client.CouchClient
+	cli    *client.CouchClient
+	health *healthChecker
 }
 
 //GetClient - Returns context
@@ -29,6 +30,22 @@ func (c *Connection) GetClient() *client.CouchClient {
 	return c.cli
 }
 
+//Use - Adds middleware that wraps every request executed by the connection, see client.Middleware
+func (c *Connection) Use(mw ...client.Middleware) {
+	c.cli.Use(mw...)
+}
+
+/*Close - Stops the health check and closes idle connections, the connection can still be used
+but it will have to establish new connections
+*/
+func (c *Connection) Close() error {
+	if c.health != nil {
+		c.health.stop()
+	}
+	c.cli.Client.CloseIdleConnections()
+	return nil
+}
+
 //GetSession - Gets a session information
 func (c *Connection) GetSession(ctx context.Context) (*response.CouchResult, error) {
 
@@ -38,13 +55,18 @@ func (c *Connection) GetSession(ctx context.Context) (*response.CouchResult, err
 		return nil, err
 	}
 	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endPointSession)
 
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 
 }
 
 /*Session - Establishes a new session. If successful then the returned cookie will be atached to context making it possible to call
-db specific endpoints.
+db specific endpoints. Credentials are stored in the client, so an expired session can be renewed automatically.
 */
 func (c *Connection) Session(ctx context.Context, user, password string) (*response.CouchResult, error) {
 
@@ -70,7 +92,14 @@ func (c *Connection) Session(ctx context.Context, user, password string) (*respo
 	}
 
 	rs, err := request.Execute(ctx)
-	c.cli.AuthData = rs.Cookie.Value
+	if err != nil {
+		return nil, err
+	}
+
+	if err = response.CheckStatus(&rs, endPointSession); err == nil && rs.Cookie.Value != "" {
+		c.cli.SetAuthData(rs.Cookie.Value)
+		c.cli.SetCredentials(user, password)
+	}
 
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 }
@@ -86,6 +115,11 @@ func (c *Connection) Up(ctx context.Context) (*response.CouchResult, error) {
 	}
 
 	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endPointUp)
 
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 }
@@ -107,6 +141,11 @@ func (c *Connection) Uuid(ctx context.Context, count int) (*response.CouchResult
 	}
 
 	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endPointUuids)
 
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 }
@@ -122,6 +161,11 @@ func (c *Connection) AllDbs(ctx context.Context) (*response.CouchResult, error)
 	}
 
 	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endPointAllDbs)
 
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 
@@ -145,7 +189,7 @@ func (c *Connection) DbsInfo(ctx context.Context, name string) (*response.CouchR
 
 	b := request.NewRequestBuilder()
 	request, err := b.WithEndpoint(endPointDbsInfo).WithMethod(request.MethodPost).
-		WithBody(doc).
+		WithBody(doc).WithIdempotent(true).
 		Build(c.cli)
 
 	if err != nil {
@@ -153,6 +197,11 @@ func (c *Connection) DbsInfo(ctx context.Context, name string) (*response.CouchR
 	}
 
 	rs, err := request.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endPointDbsInfo)
 
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 
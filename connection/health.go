@@ -0,0 +1,78 @@
+package connection
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/przebro/couchdb/client"
+)
+
+//healthChecker - Periodically checks nodes of a cluster
+type healthChecker struct {
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func (h *healthChecker) stop() {
+	h.once.Do(func() {
+		close(h.done)
+	})
+	h.wg.Wait()
+}
+
+//startHealthCheck - Starts a background check of nodes
+func (c *Connection) startHealthCheck(interval time.Duration) {
+
+	h := &healthChecker{done: make(chan struct{})}
+	c.health = h
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				c.CheckNodes(ctx)
+				cancel()
+			}
+		}
+	}()
+}
+
+//CheckNodes - Checks all nodes of a cluster with the _up endpoint and marks them as healthy or unhealthy
+func (c *Connection) CheckNodes(ctx context.Context) {
+
+	pool := c.cli.Nodes()
+	if pool == nil {
+		return
+	}
+
+	wg := sync.WaitGroup{}
+
+	for _, n := range pool.Nodes() {
+		wg.Add(1)
+		go func(node *client.Node) {
+			defer wg.Done()
+
+			res, err := c.Up(client.WithNode(ctx, node))
+			if err != nil {
+				node.SetHealthy(false)
+				return
+			}
+
+			res.Close()
+			node.SetHealthy(res.Code < 400)
+		}(n)
+	}
+
+	wg.Wait()
+}
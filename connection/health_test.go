@@ -0,0 +1,144 @@
+package connection
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/przebro/couchdb/client"
+)
+
+func splitAddress(t *testing.T, addr string) (string, int) {
+	u, _ := url.Parse(addr)
+	p, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Hostname(), p
+}
+
+func TestClusterFailover(t *testing.T) {
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dhost, dport := splitAddress(t, down.URL)
+	down.Close()
+
+	conn, err := NewBuilder().WithAddress(dhost, dport).
+		WithNodes(srv.Listener.Addr().String()).
+		Build(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := conn.GetClient().Nodes().Nodes()
+	if len(nodes) != 2 {
+		t.Fatal("unexpected result")
+	}
+
+	result, err := conn.Up(context.Background())
+	if err != nil || result.Code != 200 {
+		t.Fatal("unexpected result:", err)
+	}
+
+	if nodes[0].Healthy() || !nodes[1].Healthy() {
+		t.Error("unexpected result")
+	}
+
+	//unhealthy node is skipped
+	conn.Up(context.Background())
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Error("unexpected result:", atomic.LoadInt32(&calls))
+	}
+
+	//non idempotent requests do not fail over
+	nodes[0].SetHealthy(true)
+	_, err = conn.Session(context.Background(), username, password)
+	if err == nil {
+		t.Error("unexpected result")
+	}
+
+	conn.CheckNodes(context.Background())
+	if nodes[0].Healthy() || !nodes[1].Healthy() {
+		t.Error("unexpected result")
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+
+	var up int32 = 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&up) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer other.Close()
+
+	host, port := splitAddress(t, srv.URL)
+
+	conn, err := NewBuilder().WithAddress(host, port).
+		WithNodes(other.Listener.Addr().String()).
+		WithNodeSelector(&client.RoundRobin{}).
+		WithHealthCheck(10 * time.Millisecond).
+		Build(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := conn.GetClient().Nodes().Nodes()
+
+	time.Sleep(50 * time.Millisecond)
+	if nodes[0].Healthy() || !nodes[1].Healthy() {
+		t.Error("unexpected result")
+	}
+
+	atomic.StoreInt32(&up, 1)
+	time.Sleep(50 * time.Millisecond)
+	if !nodes[0].Healthy() || !nodes[1].Healthy() {
+		t.Error("unexpected result")
+	}
+
+	conn.Close()
+}
+
+func TestRoundRobin(t *testing.T) {
+
+	a, b := client.NewNode("a"), client.NewNode("b")
+	pool := client.NewNodePool(&client.RoundRobin{}, a, b)
+
+	if pool.Next(nil) != a || pool.Next(nil) != b || pool.Next(nil) != a {
+		t.Error("unexpected result")
+	}
+
+	a.SetHealthy(false)
+	if pool.Next(nil) != b || pool.Next(nil) != b {
+		t.Error("unexpected result")
+	}
+
+	if pool.Next(map[*client.Node]bool{b: true}) != a {
+		t.Error("unexpected result")
+	}
+
+	if pool.Next(map[*client.Node]bool{a: true, b: true}) != nil {
+		t.Error("unexpected result")
+	}
+}
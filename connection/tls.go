@@ -0,0 +1,152 @@
+package connection
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+var errNoCertificates = errors.New("no valid certificates found in root certificate authority data")
+
+//buildTLSConfig - Builds new TLS configuration with provided certificates
+func (b *builder) buildTLSConfig() (*tls.Config, error) {
+
+	tlscfg := &tls.Config{}
+	if b.tlsConfig != nil {
+		tlscfg = b.tlsConfig.Clone()
+	}
+
+	if b.skipVerify {
+		tlscfg.InsecureSkipVerify = true
+	}
+
+	pool, err := b.buildCertPool()
+	if err != nil {
+		return nil, err
+	}
+
+	//without a root certificate authority, the pool from configuration or the system pool is used
+	if pool != nil {
+		tlscfg.RootCAs = pool
+	}
+
+	/*
+		check if both client key and client certificate are provided if not,
+		continue, however, a server may be configured to require a client's certificate and reject a connection without a client's certificate
+	*/
+	switch {
+	case b.reload && b.certpath != "" && b.keypath != "":
+		{
+			reloader := &certReloader{keypath: b.keypath, certpath: b.certpath}
+			if _, err := reloader.GetClientCertificate(nil); err != nil {
+				return nil, err
+			}
+			tlscfg.GetClientCertificate = reloader.GetClientCertificate
+		}
+	case b.certpath != "" && b.keypath != "":
+		{
+			cert, err := tls.LoadX509KeyPair(b.certpath, b.keypath)
+			if err != nil {
+				return nil, err
+			}
+			tlscfg.Certificates = []tls.Certificate{cert}
+		}
+	case b.certPEM != nil && b.keyPEM != nil:
+		{
+			cert, err := tls.X509KeyPair(b.certPEM, b.keyPEM)
+			if err != nil {
+				return nil, err
+			}
+			tlscfg.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	return tlscfg, nil
+}
+
+//buildCertPool - Builds a pool with a root certificate authority, returns nil if the authority is not provided
+func (b *builder) buildCertPool() (*x509.CertPool, error) {
+
+	data := b.caPEM
+
+	if b.cert || b.caPath != "" {
+		var err error
+		if data, err = ioutil.ReadFile(b.caPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+
+	if b.systemRoots {
+		var err error
+		if pool, err = x509.SystemCertPool(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errNoCertificates
+	}
+
+	return pool, nil
+}
+
+/*certReloader - Loads a client certificate and loads it again when the key or the certificate file is modified.
+If the new pair cannot be loaded e.g. only one of files is already replaced, then the previous certificate is used.
+*/
+type certReloader struct {
+	keypath  string
+	certpath string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	keyMod  time.Time
+	certMod time.Time
+}
+
+//GetClientCertificate - Returns the current certificate, satisfies tls.Config.GetClientCertificate
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keyInfo, kerr := os.Stat(r.keypath)
+	certInfo, cerr := os.Stat(r.certpath)
+
+	if kerr != nil || cerr != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		if kerr != nil {
+			return nil, kerr
+		}
+		return nil, cerr
+	}
+
+	if r.cert != nil && keyInfo.ModTime().Equal(r.keyMod) && certInfo.ModTime().Equal(r.certMod) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certpath, r.keypath)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, err
+	}
+
+	r.cert = &cert
+	r.keyMod = keyInfo.ModTime()
+	r.certMod = certInfo.ModTime()
+
+	return r.cert, nil
+}
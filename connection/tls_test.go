@@ -0,0 +1,155 @@
+package connection
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func generateCertificate(t *testing.T, name string) ([]byte, []byte) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestPEMCertificate(t *testing.T) {
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	sport, _ := strconv.Atoi(u.Port())
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	ckey, ccert := generateCertificate(t, "client")
+
+	conn, err := NewBuilder().WithAddress(u.Hostname(), sport).
+		WithPEMCertificate(ca, ckey, ccert, false).
+		WithTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}).
+		Build(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := conn.GetClient().Client.Transport.(*http.Transport).TLSClientConfig
+	if cfg.MinVersion != tls.VersionTLS12 || len(cfg.Certificates) != 1 {
+		t.Error("unexpected result")
+	}
+
+	result, err := conn.Up(context.Background())
+	if err != nil || result.Code != 200 {
+		t.Error("unexpected result:", err)
+	}
+
+	_, err = NewBuilder().WithAddress(u.Hostname(), sport).WithPEMCertificate([]byte("not a certificate"), nil, nil, false).Build(false)
+	if err != errNoCertificates {
+		t.Error("unexpected result:", err)
+	}
+
+	//the server certificate is not trusted by the system pool
+	_, err = NewBuilder().WithAddress(u.Hostname(), sport).WithTLSConfig(&tls.Config{}).Build(true)
+	if err == nil {
+		t.Error("unexpected result")
+	}
+
+	conn, err = NewBuilder().WithAddress(u.Hostname(), sport).WithPEMCertificate(ca, nil, nil, false).WithSystemCertPool(true).Build(true)
+	if err != nil {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestClientCertificateReload(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "couchdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "client.key")
+	certPath := filepath.Join(dir, "client.crt")
+
+	key, cert := generateCertificate(t, "first")
+	ioutil.WriteFile(keyPath, key, 0600)
+	ioutil.WriteFile(certPath, cert, 0600)
+
+	reloader := &certReloader{keypath: keyPath, certpath: certPath}
+	first, err := reloader.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same, _ := reloader.GetClientCertificate(nil)
+	if same != first {
+		t.Error("unexpected result")
+	}
+
+	key, cert = generateCertificate(t, "second")
+	ioutil.WriteFile(keyPath, key, 0600)
+	ioutil.WriteFile(certPath, cert, 0600)
+
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(keyPath, later, later)
+	os.Chtimes(certPath, later, later)
+
+	second, err := reloader.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if second == first {
+		t.Error("unexpected result")
+	}
+
+	//broken pair, the previous certificate should be used
+	ioutil.WriteFile(certPath, []byte("broken"), 0600)
+	os.Chtimes(certPath, later.Add(time.Minute), later.Add(time.Minute))
+
+	third, err := reloader.GetClientCertificate(nil)
+	if err != nil || third != second {
+		t.Error("unexpected result:", err)
+	}
+
+	_, err = NewBuilder().WithAddress(host, sport).WithClientCertificateReload(keyPath, filepath.Join(dir, "missing.crt")).Build(false)
+	if err == nil {
+		t.Error("unexpected result")
+	}
+}
@@ -4,11 +4,48 @@ import (
 	"context"
 )
 
-//QueryMeta - Contains bookmark and number of documents
+//ExecutionStats - Statistics of a query, returned by the server only if execution stats were requested
+type ExecutionStats struct {
+	TotalKeysExamined       int     `json:"total_keys_examined"`
+	TotalDocsExamined       int     `json:"total_docs_examined"`
+	TotalQuorumDocsExamined int     `json:"total_quorum_docs_examined"`
+	ResultsReturned         int     `json:"results_returned"`
+	ExecutionTimeMs         float64 `json:"execution_time_ms"`
+}
+
+//Add - Adds statistics of an another page
+func (s *ExecutionStats) Add(other ExecutionStats) {
+	s.TotalKeysExamined += other.TotalKeysExamined
+	s.TotalDocsExamined += other.TotalDocsExamined
+	s.TotalQuorumDocsExamined += other.TotalQuorumDocsExamined
+	s.ResultsReturned += other.ResultsReturned
+	s.ExecutionTimeMs += other.ExecutionTimeMs
+}
+
+/*QueryMeta - Contains bookmark, warning and statistics. Bookmark and Warning refer to the current page,
+Stats and Documents are aggregated across all pages fetched so far, PageStats refer to the current page.
+*/
 type QueryMeta struct {
 	Bookmark  string
 	Documents int
 	Warning   string
+	Stats     ExecutionStats
+	PageStats ExecutionStats
+}
+
+//AddPage - Updates the meta with a next page, stats are nil if they were not returned
+func (m *QueryMeta) AddPage(bookmark, warning string, stats *ExecutionStats) {
+
+	m.Bookmark = bookmark
+	m.Warning = warning
+	m.PageStats = ExecutionStats{}
+
+	if stats != nil {
+		m.PageStats = *stats
+		m.Stats.Add(*stats)
+	}
+
+	m.Documents = m.Stats.ResultsReturned
 }
 
 //ResultCursor - Helps iterate over returned result
@@ -23,4 +60,6 @@ type ResultCursor interface {
 	Meta() QueryMeta
 	//Close closes cursor
 	Close(ctx context.Context) error
+	//Err - returns an error that stopped the iteration, nil if the cursor was exhausted or is still open
+	Err() error
 }
@@ -0,0 +1,79 @@
+package cursor
+
+import (
+	"context"
+)
+
+//Cursor - A typed cursor that decodes documents into values of type T
+type Cursor[T any] struct {
+	crsr  ResultCursor
+	value T
+	err   error
+}
+
+//NewCursor - Creates a typed cursor over a ResultCursor
+func NewCursor[T any](crsr ResultCursor) *Cursor[T] {
+	return &Cursor[T]{crsr: crsr}
+}
+
+/*Next - Moves to the next document and decodes it, returns false if there are no more documents
+or the document could not be decoded, check Err to tell them apart
+*/
+func (c *Cursor[T]) Next(ctx context.Context) bool {
+
+	if c.err != nil || c.crsr == nil {
+		return false
+	}
+
+	if !c.crsr.Next(ctx) {
+		return false
+	}
+
+	var value T
+	if c.err = c.crsr.Decode(&value); c.err != nil {
+		return false
+	}
+
+	c.value = value
+	return true
+}
+
+//Value - Returns the current document
+func (c *Cursor[T]) Value() T {
+	return c.value
+}
+
+//Err - Returns an error that stopped the iteration, either a decode error or an error of the underlying cursor
+func (c *Cursor[T]) Err() error {
+	if c.err != nil || c.crsr == nil {
+		return c.err
+	}
+	return c.crsr.Err()
+}
+
+//All - Returns all remaining documents, documents decoded before an error occurred are returned along with the error
+func (c *Cursor[T]) All(ctx context.Context) ([]T, error) {
+
+	values := []T{}
+	for c.Next(ctx) {
+		values = append(values, c.value)
+	}
+
+	return values, c.Err()
+}
+
+//Meta - returns meta information for current resultset
+func (c *Cursor[T]) Meta() QueryMeta {
+	if c.crsr == nil {
+		return QueryMeta{}
+	}
+	return c.crsr.Meta()
+}
+
+//Close - closes the underlying cursor
+func (c *Cursor[T]) Close(ctx context.Context) error {
+	if c.crsr == nil {
+		return nil
+	}
+	return c.crsr.Close(ctx)
+}
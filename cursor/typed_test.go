@@ -0,0 +1,70 @@
+package cursor
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+type sliceCursor struct {
+	docs []string
+	pos  int
+}
+
+func (s *sliceCursor) All(ctx context.Context, v interface{}) error { return nil }
+func (s *sliceCursor) Next(ctx context.Context) bool {
+	s.pos++
+	return s.pos <= len(s.docs)
+}
+func (s *sliceCursor) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(s.docs[s.pos-1]), v)
+}
+func (s *sliceCursor) Meta() QueryMeta                 { return QueryMeta{Bookmark: "bookmark"} }
+func (s *sliceCursor) Close(ctx context.Context) error { return nil }
+func (s *sliceCursor) Err() error                      { return nil }
+
+type movie struct {
+	Title string `json:"title"`
+	Year  int    `json:"year"`
+}
+
+func TestCursor(t *testing.T) {
+
+	crsr := NewCursor[movie](&sliceCursor{docs: []string{`{"title":"Alien","year":1979}`, `{"title":"Aliens","year":1986}`}})
+
+	movies, err := crsr.All(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(movies) != 2 || movies[1].Title != "Aliens" || crsr.Meta().Bookmark != "bookmark" {
+		t.Error("unexpected result:", movies)
+	}
+}
+
+func TestCursorDecodeError(t *testing.T) {
+
+	crsr := NewCursor[movie](&sliceCursor{docs: []string{`{"title":"Alien","year":1979}`, `{"title":"Aliens","year":"1986"}`, `{}`}})
+
+	if !crsr.Next(context.Background()) || crsr.Value().Year != 1979 {
+		t.Error("unexpected result:", crsr.Value())
+	}
+
+	movies, err := crsr.All(context.Background())
+	if err == nil || len(movies) != 0 {
+		t.Error("unexpected result:", movies, err)
+	}
+
+	if crsr.Next(context.Background()) || crsr.Err() == nil {
+		t.Error("unexpected result:", crsr.Err())
+	}
+}
+
+func TestCursorZeroValue(t *testing.T) {
+
+	crsr := Cursor[movie]{}
+
+	if crsr.Next(context.Background()) || crsr.Err() != nil || crsr.Meta().Bookmark != "" || crsr.Close(context.Background()) != nil {
+		t.Error("unexpected result")
+	}
+}
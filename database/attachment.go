@@ -0,0 +1,223 @@
+package database
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/przebro/couchdb/request"
+	"github.com/przebro/couchdb/response"
+)
+
+//AttachmentInfo - Contains attachment details returned in response headers
+type AttachmentInfo struct {
+	*response.CouchStatus
+	//ContentType - MIME type of the attachment
+	ContentType string
+	//Digest - MD5 digest of the attachment in the same form as the digest field of the document stub e.g. md5-...
+	Digest string
+	//Length - number of bytes sent in the response, for a compressed attachment it is the compressed size, for a range request it is the length of the range
+	Length int64
+	//Encoding - compression codec, available only if the attachment is transferred compressed, the content is decompressed before it is written
+	Encoding string
+	//ContentRange - a range returned for a range request e.g. bytes 0-99/1024
+	ContentRange string
+}
+
+//PutAttachment - Uploads an attachment to a document, the content is streamed from the reader. Rev is required if the document exists
+func (db *CouchDatabase) PutAttachment(ctx context.Context, id, rev, name, contentType string, rdr io.Reader) (*response.CouchResult, error) {
+
+	if id == "" {
+		return nil, errEmptyDocumentID
+	}
+
+	if name == "" {
+		return nil, errEmptyAttachmentName
+	}
+
+	if rdr == nil {
+		return nil, errNilAttachmentBody
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	qParams := map[string]string{}
+	if rev != "" {
+		qParams["rev"] = rev
+	}
+
+	endpoint := attachmentEndpoint(db.Name, id, name)
+	rqb := request.NewRequestBuilder()
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodPut).WithParameters(qParams).
+		WithHeaders(map[string]string{"Content-Type": contentType}).
+		WithBodyReader(rdr).
+		Build(db.cli)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endpoint)
+
+	return response.NewResult(rs.CouchStatus, rs.Rdr), err
+}
+
+/*GetAttachment - Downloads an attachment and writes it to the writer without buffering. If rev is empty,
+then the attachment of the latest revision is returned.
+*/
+func (db *CouchDatabase) GetAttachment(ctx context.Context, id, rev, name string, w io.Writer) (*AttachmentInfo, error) {
+	return db.getAttachment(ctx, id, rev, name, w, nil)
+}
+
+/*GetAttachmentRange - Downloads a part of an attachment starting at offset. If length is less than or equal to zero,
+then the rest of the attachment is returned. Note that CouchDB ignores ranges for compressed attachments
+and returns the whole content, check the ContentRange of the result.
+*/
+func (db *CouchDatabase) GetAttachmentRange(ctx context.Context, id, rev, name string, w io.Writer, offset, length int64) (*AttachmentInfo, error) {
+
+	if offset < 0 {
+		return nil, errInvalidRange
+	}
+
+	rng := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		rng = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+
+	return db.getAttachment(ctx, id, rev, name, w, map[string]string{"Range": rng})
+}
+
+func (db *CouchDatabase) getAttachment(ctx context.Context, id, rev, name string, w io.Writer, headers map[string]string) (*AttachmentInfo, error) {
+
+	if id == "" {
+		return nil, errEmptyDocumentID
+	}
+
+	if name == "" {
+		return nil, errEmptyAttachmentName
+	}
+
+	qParams := map[string]string{}
+	if rev != "" {
+		qParams["rev"] = rev
+	}
+
+	/*
+		When the Accept-Encoding is not set, the http transport requests gzip and decompresses the body transparently,
+		then the Content-Encoding and the Content-Length of the compressed attachment are lost.
+		Request gzip explicitly and decompress the content here instead.
+	*/
+	rqHeaders := map[string]string{"Accept-Encoding": "gzip"}
+	for k, v := range headers {
+		rqHeaders[k] = v
+	}
+
+	endpoint := attachmentEndpoint(db.Name, id, name)
+	rqb := request.NewRequestBuilder()
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodGet).WithParameters(qParams).
+		WithHeaders(rqHeaders).
+		Build(db.cli)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Rdr.Close()
+
+	if err = response.CheckStatus(&rs, endpoint); err != nil {
+		return &AttachmentInfo{CouchStatus: rs.CouchStatus}, err
+	}
+
+	info := attachmentInfo(rs)
+
+	counter := &countingWriter{}
+	var rdr io.Reader = io.TeeReader(rs.Rdr, counter)
+
+	if strings.EqualFold(info.Encoding, "gzip") {
+		gz, err := gzip.NewReader(rdr)
+		if err != nil {
+			return info, err
+		}
+		defer gz.Close()
+		rdr = gz
+	}
+
+	_, err = io.Copy(w, rdr)
+	if info.Length < 0 {
+		info.Length = counter.n
+	}
+
+	return info, err
+}
+
+//DeleteAttachment - Deletes an attachment of the document
+func (db *CouchDatabase) DeleteAttachment(ctx context.Context, id, rev, name string) (*response.CouchResult, error) {
+
+	if id == "" || rev == "" {
+		return nil, errIDandRevRequired
+	}
+
+	if name == "" {
+		return nil, errEmptyAttachmentName
+	}
+
+	endpoint := attachmentEndpoint(db.Name, id, name)
+	rqb := request.NewRequestBuilder()
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodDelete).
+		WithParameters(map[string]string{"rev": rev}).
+		Build(db.cli)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endpoint)
+
+	return response.NewResult(rs.CouchStatus, rs.Rdr), err
+}
+
+func attachmentEndpoint(db, id, name string) string {
+	return fmt.Sprintf("%s/%s/%s", db, documentPath(id), url.PathEscape(name))
+}
+
+//attachmentInfo - extracts attachment details from headers, the ETag contains base64 encoded MD5 digest of the attachment
+func attachmentInfo(rs response.CouchResponse) *AttachmentInfo {
+
+	info := &AttachmentInfo{CouchStatus: rs.CouchStatus, Length: -1}
+
+	if rs.Header == nil {
+		return info
+	}
+
+	info.ContentType = rs.Header.Get("Content-Type")
+	info.Encoding = rs.Header.Get("Content-Encoding")
+	info.ContentRange = rs.Header.Get("Content-Range")
+
+	if etag := strings.Trim(rs.Header.Get("ETag"), `"`); etag != "" {
+		info.Digest = "md5-" + etag
+	}
+
+	if l, err := strconv.ParseInt(rs.Header.Get("Content-Length"), 10, 64); err == nil && rs.Code != http.StatusNoContent {
+		info.Length = l
+	}
+
+	return info
+}
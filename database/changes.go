@@ -0,0 +1,580 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/przebro/couchdb/request"
+	"github.com/przebro/couchdb/response"
+)
+
+const (
+	endPointChanges = "_changes"
+	fieldResults    = "results"
+	fieldLastSeq    = "last_seq"
+
+	//DefaultReconnectDelay - a delay before a feed is reconnected after an error
+	DefaultReconnectDelay = time.Second
+	//DefaultHeartbeat - an interval of empty lines sent by the server on longpoll, continuous and eventsource feeds
+	DefaultHeartbeat = 10 * time.Second
+)
+
+//Feed - A type of the changes feed
+type Feed string
+
+//Types of feeds
+const (
+	//FeedNormal - returns all changes at once and ends
+	FeedNormal Feed = "normal"
+	//FeedLongpoll - waits for changes, every response is followed by a next request
+	FeedLongpoll Feed = "longpoll"
+	//FeedContinuous - keeps a connection open and sends changes as they occur
+	FeedContinuous Feed = "continuous"
+	//FeedEventSource - like FeedContinuous but changes are sent as server sent events
+	FeedEventSource Feed = "eventsource"
+)
+
+//StyleAllDocs - Returns all leaf revisions of a document, including conflicts
+const StyleAllDocs = "all_docs"
+
+var (
+	errInvalidFeed     = errors.New("invalid feed type")
+	errMultipleFilters = errors.New("only one of doc ids, selector, view, design or filter can be set")
+)
+
+/*Seq - A sequence of a change. CouchDB 2.x and newer return opaque strings while older versions return numbers,
+both are kept as a string. SeqNow is a special value that starts a feed from the current moment.
+*/
+type Seq string
+
+//SeqNow - Starts a feed from the current moment
+const SeqNow Seq = "now"
+
+//UnmarshalJSON - Unmarshals a sequence given either as a string or a number
+func (s *Seq) UnmarshalJSON(data []byte) error {
+
+	data = bytes.TrimSpace(data)
+
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		*s = ""
+	case len(data) > 0 && data[0] == '"':
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*s = Seq(str)
+	default:
+		*s = Seq(data)
+	}
+
+	return nil
+}
+
+//MarshalJSON - Marshals a sequence as a number if it is numeric, otherwise as a string
+func (s Seq) MarshalJSON() ([]byte, error) {
+
+	if _, err := strconv.ParseInt(string(s), 10, 64); err == nil {
+		return []byte(s), nil
+	}
+
+	return json.Marshal(string(s))
+}
+
+//ChangeRev - A revision of a changed document
+type ChangeRev struct {
+	Rev string `json:"rev"`
+}
+
+//Change - A single change of a document
+type Change struct {
+	Seq     Seq             `json:"seq"`
+	ID      string          `json:"id"`
+	Changes []ChangeRev     `json:"changes"`
+	Deleted bool            `json:"deleted,omitempty"`
+	Doc     json.RawMessage `json:"doc,omitempty"`
+}
+
+//DecodeDoc - Unmarshals the document of the change, available only if IncludeDocs is set
+func (c *Change) DecodeDoc(v interface{}) error {
+	return json.Unmarshal(c.Doc, v)
+}
+
+//changeLine - a line of a continuous feed, it is either a change or the last sequence when the feed ends
+type changeLine struct {
+	Change
+	LastSeq *Seq `json:"last_seq"`
+}
+
+/*ChangesOptions - Parameters of the changes feed. Only one filter can be set: DocIDs, Selector, View, Design or Filter,
+where Filter is a name of a filter function in the form ddoc/name and FilterParams are passed to it.
+*/
+type ChangesOptions struct {
+	Feed  Feed
+	Since Seq
+	/*Heartbeat - an interval of empty lines sent by the server while there are no changes, DefaultHeartbeat is used
+	if it is zero and the feed is not a normal one. The server starts the response at once, so it should be shorter than
+	the response header timeout of the connection.
+	*/
+	Heartbeat   time.Duration
+	Timeout     time.Duration
+	IncludeDocs bool
+	Style       string
+	Limit       int
+
+	DocIDs []string
+	//Selector - a selector in the same form as in Select
+	Selector     interface{}
+	View         string
+	Design       bool
+	Filter       string
+	FilterParams map[string]string
+
+	//ReconnectDelay - a delay before reconnecting after an error, DefaultReconnectDelay is used if it is zero
+	ReconnectDelay time.Duration
+}
+
+func (o ChangesOptions) validate() error {
+
+	switch o.Feed {
+	case "", FeedNormal, FeedLongpoll, FeedContinuous, FeedEventSource:
+	default:
+		return errInvalidFeed
+	}
+
+	filters := 0
+	for _, set := range []bool{o.DocIDs != nil, o.Selector != nil, o.View != "", o.Design, o.Filter != ""} {
+		if set {
+			filters++
+		}
+	}
+
+	if filters > 1 {
+		return errMultipleFilters
+	}
+
+	return nil
+}
+
+//params - builds query parameters, the since and the limit change when a feed is reconnected
+func (o ChangesOptions) params(since Seq, limit int) map[string]string {
+
+	params := map[string]string{}
+
+	if o.FilterParams != nil {
+		for k, v := range o.FilterParams {
+			params[k] = v
+		}
+	}
+
+	if o.Feed != "" {
+		params["feed"] = string(o.Feed)
+	}
+	if since != "" {
+		params["since"] = string(since)
+	}
+	heartbeat := o.Heartbeat
+	if heartbeat == 0 && o.Feed != "" && o.Feed != FeedNormal {
+		heartbeat = DefaultHeartbeat
+	}
+	if heartbeat > 0 {
+		params["heartbeat"] = strconv.FormatInt(heartbeat.Milliseconds(), 10)
+	}
+	if o.Timeout > 0 {
+		params["timeout"] = strconv.FormatInt(o.Timeout.Milliseconds(), 10)
+	}
+	if o.IncludeDocs {
+		params["include_docs"] = "true"
+	}
+	if o.Style != "" {
+		params["style"] = o.Style
+	}
+	if limit > 0 {
+		params["limit"] = strconv.Itoa(limit)
+	}
+
+	switch {
+	case o.DocIDs != nil:
+		params["filter"] = "_doc_ids"
+	case o.Selector != nil:
+		params["filter"] = "_selector"
+	case o.View != "":
+		params["filter"] = "_view"
+		params["view"] = o.View
+	case o.Design:
+		params["filter"] = "_design"
+	case o.Filter != "":
+		params["filter"] = o.Filter
+	}
+
+	return params
+}
+
+/*ChangesFeed - Iterates over changes of a database. Longpoll, continuous and eventsource feeds are reconnected from the last
+received sequence when a connection ends, so they are iterated until the context is cancelled, the limit is reached
+or the feed is closed. Cancelling the context is not considered an error. The timeout set with the connection builder
+limits only waiting for response headers, the heartbeat makes the server send them at once, so a feed waiting
+for changes is not reconnected.
+*/
+type ChangesFeed struct {
+	db     *CouchDatabase
+	opts   ChangesOptions
+	body   []byte
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	rdr   io.ReadCloser
+	dec   *json.Decoder
+	br    *bufio.Reader
+	read  func() (Change, bool, error)
+	since Seq
+
+	change Change
+	count  int
+	err    error
+	done   bool
+}
+
+//Changes - Opens the changes feed of a database
+func (db *CouchDatabase) Changes(ctx context.Context, opts ChangesOptions) (*ChangesFeed, error) {
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	var err error
+
+	switch {
+	case opts.DocIDs != nil:
+		body, err = json.Marshal(map[string][]string{"doc_ids": opts.DocIDs})
+	case opts.Selector != nil:
+		var sel json.RawMessage
+		if sel, err = selectorData(opts.Selector); err == nil {
+			body, err = json.Marshal(map[string]json.RawMessage{"selector": sel})
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	fctx, cancel := context.WithCancel(ctx)
+	feed := &ChangesFeed{db: db, opts: opts, body: body, ctx: fctx, cancel: cancel, since: opts.Since}
+
+	if err = feed.connect(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return feed, nil
+}
+
+//connect - opens a connection starting from the last sequence
+func (f *ChangesFeed) connect() error {
+
+	limit := 0
+	if f.opts.Limit > 0 {
+		limit = f.opts.Limit - f.count
+	}
+
+	endpoint := fmt.Sprintf("%s/%s", f.db.Name, endPointChanges)
+	rqb := request.NewRequestBuilder().WithEndpoint(endpoint).WithParameters(f.opts.params(f.since, limit))
+
+	if f.body != nil {
+		rqb.WithMethod(request.MethodPost).WithBody(f.body).WithIdempotent(true)
+	} else {
+		rqb.WithMethod(request.MethodGet)
+	}
+
+	rq, err := rqb.Build(f.db.cli)
+	if err != nil {
+		return err
+	}
+
+	rs, err := rq.Execute(f.ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = response.CheckStatus(&rs, endpoint); err != nil {
+		rs.Rdr.Close()
+		return err
+	}
+
+	f.rdr = rs.Rdr
+
+	switch f.opts.Feed {
+	case FeedContinuous:
+		f.dec = json.NewDecoder(f.rdr)
+		f.read = f.readContinuous
+	case FeedEventSource:
+		f.br = bufio.NewReader(f.rdr)
+		f.read = f.readEvent
+	default:
+		f.dec = json.NewDecoder(f.rdr)
+		if err = f.readHeader(); err != nil {
+			f.disconnect()
+			return err
+		}
+		f.read = f.readResults
+	}
+
+	return nil
+}
+
+func (f *ChangesFeed) disconnect() {
+	if f.rdr != nil {
+		f.rdr.Close()
+		f.rdr = nil
+	}
+}
+
+//readHeader - reads fields of a normal or longpoll response until the results array
+func (f *ChangesFeed) readHeader() error {
+
+	if err := expectDelim(f.dec, '{'); err != nil {
+		return err
+	}
+
+	return f.readFields()
+}
+
+//readFields - reads fields of a response, stops at the beginning of results or at the end of the object
+func (f *ChangesFeed) readFields() error {
+
+	for f.dec.More() {
+
+		field, err := f.dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch field {
+		case fieldResults:
+			return expectDelim(f.dec, '[')
+		case fieldLastSeq:
+			var seq Seq
+			if err = f.dec.Decode(&seq); err == nil && seq != "" {
+				f.since = seq
+			}
+		default:
+			err = f.dec.Decode(&json.RawMessage{})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(f.dec, '}')
+}
+
+//readResults - reads a change of a normal or longpoll response, returns true when the response ends
+func (f *ChangesFeed) readResults() (Change, bool, error) {
+
+	change := Change{}
+
+	if f.dec.More() {
+		err := f.dec.Decode(&change)
+		return change, false, err
+	}
+
+	if err := expectDelim(f.dec, ']'); err != nil {
+		return change, true, err
+	}
+
+	return change, true, f.readFields()
+}
+
+//readContinuous - reads a line of a continuous feed, heartbeats are skipped by the decoder as whitespace
+func (f *ChangesFeed) readContinuous() (Change, bool, error) {
+
+	line := changeLine{}
+	if err := f.dec.Decode(&line); err != nil {
+		if err == io.EOF {
+			return line.Change, true, nil
+		}
+		return line.Change, true, err
+	}
+
+	if line.LastSeq != nil {
+		f.since = *line.LastSeq
+		return line.Change, true, nil
+	}
+
+	return line.Change, false, nil
+}
+
+//readEvent - reads an event of an eventsource feed, only data lines are interpreted
+func (f *ChangesFeed) readEvent() (Change, bool, error) {
+
+	event, payload := "", []byte{}
+
+	for {
+		data, err := f.br.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			if err == io.EOF {
+				return Change{}, true, nil
+			}
+			return Change{}, true, err
+		}
+
+		data = bytes.TrimSpace(data)
+
+		switch {
+		case bytes.HasPrefix(data, []byte("event:")):
+			event = string(bytes.TrimSpace(data[len("event:"):]))
+		case bytes.HasPrefix(data, []byte("data:")):
+			payload = append(payload, bytes.TrimSpace(data[len("data:"):])...)
+		case len(data) == 0:
+			//an empty line ends the event, heartbeats and other events without data do not carry changes
+			if len(payload) == 0 || (event != "" && event != "message") {
+				event, payload = "", payload[:0]
+				continue
+			}
+
+			line := changeLine{}
+			if err = json.Unmarshal(payload, &line); err != nil {
+				return Change{}, true, err
+			}
+
+			if line.LastSeq != nil {
+				f.since = *line.LastSeq
+				return line.Change, true, nil
+			}
+
+			return line.Change, false, nil
+		}
+	}
+}
+
+//Next - Waits for the next change, returns false if the feed ended or an error occurred, check Err to tell them apart
+func (f *ChangesFeed) Next() bool {
+
+	for !f.done && f.err == nil {
+
+		if f.opts.Limit > 0 && f.count >= f.opts.Limit {
+			break
+		}
+
+		if f.rdr == nil {
+			if err := f.connect(); err != nil {
+				if !f.retryable(err) {
+					f.err = err
+					break
+				}
+				f.wait()
+				continue
+			}
+		}
+
+		change, end, err := f.read()
+		if err == nil && !end {
+			f.change = change
+			f.since = change.Seq
+			f.count++
+			return true
+		}
+
+		f.disconnect()
+
+		//the feed was closed or the context was cancelled
+		if f.ctx.Err() != nil {
+			break
+		}
+
+		if f.opts.Feed == "" || f.opts.Feed == FeedNormal {
+			f.err = err
+			break
+		}
+
+		if err != nil {
+			if !f.retryable(err) {
+				f.err = err
+				break
+			}
+			f.wait()
+		}
+	}
+
+	f.close()
+
+	return false
+}
+
+//retryable - checks if a feed can be reconnected after an error, errors caused by the context end the feed without an error
+func (f *ChangesFeed) retryable(err error) bool {
+
+	if f.ctx.Err() != nil {
+		f.done = true
+		return true
+	}
+
+	cerr := &response.CouchError{}
+	if errors.As(err, &cerr) {
+		return cerr.StatusCode >= 500
+	}
+
+	return true
+}
+
+//wait - waits before reconnecting, ends the feed if the context is done
+func (f *ChangesFeed) wait() {
+
+	if f.done {
+		return
+	}
+
+	delay := f.opts.ReconnectDelay
+	if delay == 0 {
+		delay = DefaultReconnectDelay
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-f.ctx.Done():
+		f.done = true
+	}
+}
+
+func (f *ChangesFeed) close() {
+	f.done = true
+	f.disconnect()
+	f.cancel()
+}
+
+//Change - Returns the current change
+func (f *ChangesFeed) Change() Change {
+	return f.change
+}
+
+//LastSeq - Returns a sequence of the last received change, it can be used as Since to resume the feed later
+func (f *ChangesFeed) LastSeq() Seq {
+	return f.since
+}
+
+//Err - Returns an error that stopped the feed
+func (f *ChangesFeed) Err() error {
+	return f.err
+}
+
+//Close - Closes the feed, a blocked Next returns false
+func (f *ChangesFeed) Close() error {
+	f.cancel()
+	return nil
+}
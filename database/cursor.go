@@ -13,6 +13,7 @@ import (
 	"github.com/przebro/couchdb/request"
 
 	"github.com/przebro/couchdb/cursor"
+	"github.com/przebro/couchdb/response"
 )
 
 type bufferedCursor struct {
@@ -22,73 +23,69 @@ type bufferedCursor struct {
 	cli       *client.CouchClient
 	sel       DataSelector
 	meta      cursor.QueryMeta
+	warn      warningHandler
+	err       error
 }
 
+//errorCursor - a cursor returned when a query failed, so a result never contains a nil cursor
+type errorCursor struct {
+	err error
+}
+
+func (c *errorCursor) All(ctx context.Context, v interface{}) error { return c.err }
+func (c *errorCursor) Next(ctx context.Context) bool               { return false }
+func (c *errorCursor) Decode(v interface{}) error                  { return c.err }
+func (c *errorCursor) Meta() cursor.QueryMeta                      { return cursor.QueryMeta{} }
+func (c *errorCursor) Close(ctx context.Context) error             { return nil }
+func (c *errorCursor) Err() error                                  { return c.err }
+
 type responseResult struct {
 	Data     json.RawMessage        `json:"docs"`
 	Bookmark string                 `json:"bookmark"`
 	Warning  string                 `json:"warning"`
-	Stats    map[string]interface{} `json:"execution_stats"`
+	Stats    *cursor.ExecutionStats `json:"execution_stats"`
 }
 
-func newBufferedCursor(rdr io.ReadCloser, ep string, sel DataSelector, cli *client.CouchClient) *bufferedCursor {
+func newBufferedCursor(rdr io.ReadCloser, ep string, sel DataSelector, cli *client.CouchClient, warn warningHandler) cursor.ResultCursor {
 
-	var docs int
 	result, err := getResultset(rdr)
 
 	if err != nil {
-		return nil
+		return &errorCursor{err: err}
 	}
 
-	if result.Stats != nil {
-		docs = int(result.Stats["results_returned"].(float64))
-	}
-
-	return &bufferedCursor{
+	s := &bufferedCursor{
 		resultset: result.Data,
 		ep:        ep,
 		sel:       sel,
 		cli:       cli,
-		meta:      cursor.QueryMeta{Warning: result.Warning, Bookmark: result.Bookmark, Documents: docs},
+		warn:      warn,
 	}
+	s.meta.AddPage(result.Bookmark, result.Warning, result.Stats)
+	s.err = s.warn.handle(result.Warning)
+
+	return s
 }
 
 func (s *bufferedCursor) All(ctx context.Context, v interface{}) error {
-
-	rval := reflect.ValueOf(v)
-	if rval.Kind() != reflect.Ptr {
-		return errInvalidDocKind
-	}
-
-	sval := rval.Elem()
-	if sval.Kind() == reflect.Interface {
-		sval = sval.Elem()
-	}
-
-	if sval.Kind() != reflect.Slice {
-		return errInvalidDocKind
-	}
-
-	etype := sval.Type().Elem()
-
-	for s.Next(ctx) {
-
-		newElem := reflect.New(etype)
-		i := newElem.Interface()
-		s.Decode(i)
-		sval.Set(reflect.Append(sval, newElem.Elem()))
-
-	}
-
-	return nil
+	return allDocuments(ctx, s, v)
 }
 
 func (s *bufferedCursor) Next(ctx context.Context) bool {
 
+	if s.err != nil {
+		return false
+	}
+
 	if s.dec == nil {
 
+		if s.resultset == nil {
+			return false
+		}
+
 		s.dec = createDecoder(s.resultset)
 		if _, err := s.dec.Token(); err != nil {
+			s.err = err
 			return false
 		}
 	}
@@ -115,30 +112,38 @@ func (s *bufferedCursor) Close(ctx context.Context) error {
 	return nil
 }
 
+func (s *bufferedCursor) Err() error {
+	return s.err
+}
+
 func (s *bufferedCursor) fetchNextResultset(ctx context.Context) bool {
 
 	if ctx == nil {
 		ctx = context.Background()
 	}
 
-	s.sel.Bookmark = s.meta.Bookmark
-	selct, err := json.Marshal(s.sel)
-
-	rqb := request.NewRequestBuilder()
-	request, err := rqb.WithEndpoint(s.ep).WithMethod(request.MethodPost).WithBody(selct).Build(s.cli)
+	if s.cli == nil {
+		return false
+	}
 
-	r, err := request.Execute(ctx)
+	s.sel.Bookmark = s.meta.Bookmark
+	rdr, err := requestPage(ctx, s.cli, s.ep, s.sel)
 	if err != nil {
+		s.err = err
 		return false
 	}
-	doc, err := getResultset(r.Rdr)
 
+	doc, err := getResultset(rdr)
 	if err != nil {
+		s.err = err
 		return false
 	}
 
 	s.resultset = doc.Data
-	s.meta = cursor.QueryMeta{Bookmark: doc.Bookmark, Documents: 0, Warning: doc.Warning}
+	s.meta.AddPage(doc.Bookmark, doc.Warning, doc.Stats)
+	if s.err = s.warn.handle(doc.Warning); s.err != nil {
+		return false
+	}
 
 	s.dec = createDecoder(s.resultset)
 	s.dec.Token()
@@ -146,12 +151,44 @@ func (s *bufferedCursor) fetchNextResultset(ctx context.Context) bool {
 	return checkDocuments(s.resultset)
 }
 
+//requestPage - requests a page of results and returns a body of the response
+func requestPage(ctx context.Context, cli *client.CouchClient, ep string, sel DataSelector) (io.ReadCloser, error) {
+
+	data, err := json.Marshal(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	rqb := request.NewRequestBuilder()
+	rq, err := rqb.WithEndpoint(ep).WithMethod(request.MethodPost).WithBody(data).WithIdempotent(true).Build(cli)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = response.CheckStatus(&rs, ep); err != nil {
+		rs.Rdr.Close()
+		return nil, err
+	}
+
+	return rs.Rdr, nil
+}
+
 func getResultset(rdr io.ReadCloser) (responseResult, error) {
 
-	data, _ := ioutil.ReadAll(rdr)
+	defer rdr.Close()
+
+	data, err := ioutil.ReadAll(rdr)
 	dc := responseResult{}
+	if err != nil {
+		return dc, err
+	}
 
-	err := json.Unmarshal(data, &dc)
+	err = json.Unmarshal(data, &dc)
 	if err != nil {
 		return dc, err
 	}
@@ -175,3 +212,36 @@ func checkDocuments(data []byte) bool {
 
 	return dec.More()
 }
+
+//allDocuments - decodes all remaining documents of a cursor into v, which must be a pointer to a slice
+func allDocuments(ctx context.Context, s cursor.ResultCursor, v interface{}) error {
+
+	rval := reflect.ValueOf(v)
+	if rval.Kind() != reflect.Ptr {
+		return errInvalidDocKind
+	}
+
+	sval := rval.Elem()
+	if sval.Kind() == reflect.Interface {
+		sval = sval.Elem()
+	}
+
+	if sval.Kind() != reflect.Slice {
+		return errInvalidDocKind
+	}
+
+	etype := sval.Type().Elem()
+
+	for s.Next(ctx) {
+
+		newElem := reflect.New(etype)
+		i := newElem.Interface()
+		if err := s.Decode(i); err != nil {
+			return err
+		}
+		sval.Set(reflect.Append(sval, newElem.Elem()))
+
+	}
+
+	return s.Err()
+}
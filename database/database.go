@@ -3,10 +3,10 @@ package database
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 
 	"github.com/przebro/couchdb/client"
+	"github.com/przebro/couchdb/cursor"
 	"github.com/przebro/couchdb/request"
 	"github.com/przebro/couchdb/response"
 )
@@ -22,16 +22,14 @@ func CreateDatabase(ctx context.Context, name string, cli *client.CouchClient) (
 		return nil, database, err
 	}
 	rs, err := request.Execute(ctx)
-
-	if err == nil {
-		database.cli = cli
-		database.Name = name
-
+	if err != nil {
+		return nil, database, err
 	}
 
-	if rs.Code >= response.StatusCode400BadRequest {
-		err = errors.New(rs.Status)
-	}
+	database.cli = cli
+	database.Name = name
+
+	err = response.CheckStatus(&rs, name)
 
 	return response.NewResult(rs.CouchStatus, rs.Rdr), database, err
 }
@@ -47,15 +45,14 @@ func GetDatabsase(ctx context.Context, name string, cli *client.CouchClient) (*r
 		return nil, database, err
 	}
 	rs, err := rq.Execute(ctx)
-
-	if err == nil {
-		database.cli = cli
-		database.Name = name
+	if err != nil {
+		return nil, database, err
 	}
 
-	if rs.Code == response.StatusCode404NotFound {
-		err = errors.New(rs.Status)
-	}
+	database.cli = cli
+	database.Name = name
+
+	err = response.CheckStatus(&rs, name)
 
 	return response.NewResult(rs.CouchStatus, rs.Rdr), database, err
 
@@ -71,11 +68,12 @@ func DropDatabase(ctx context.Context, name string, cli *client.CouchClient) (*r
 		return nil, err
 	}
 	rs, err := rq.Execute(ctx)
-
-	if rs.Code >= response.StatusCode400BadRequest {
-		err = errors.New(rs.Status)
+	if err != nil {
+		return nil, err
 	}
 
+	err = response.CheckStatus(&rs, name)
+
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 }
 
@@ -95,11 +93,12 @@ func (db *CouchDatabase) Get(ctx context.Context, id string) (*response.CouchRes
 	}
 
 	rs, err := request.Execute(ctx)
-
-	if rs.Code >= response.StatusCode400BadRequest {
-		err = errors.New(rs.Status)
+	if err != nil {
+		return nil, err
 	}
 
+	err = response.CheckStatus(&rs, endpoint)
+
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 }
 
@@ -113,45 +112,74 @@ func (db *CouchDatabase) Stat(ctx context.Context) (*response.CouchResult, error
 		return nil, err
 	}
 	rs, err := request.Execute(ctx)
-
-	if rs.Code >= response.StatusCode400BadRequest {
-		err = errors.New(rs.Status)
+	if err != nil {
+		return nil, err
 	}
 
+	err = response.CheckStatus(&rs, db.Name)
+
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 }
 
-//Select - Selects documents from the database.
-func (db *CouchDatabase) Select(ctx context.Context, sel string, fld []string, opt map[FindOption]interface{}) (*response.CouchMultiResult, error) {
+/*Select - Selects documents from the database. The selector is either a JSON given as a string, []byte or json.RawMessage,
+or a value implementing json.Marshaler, like an expression built with the selector package.
+*/
+func (db *CouchDatabase) Select(ctx context.Context, sel interface{}, fld []string, opt map[FindOption]interface{}) (*response.CouchMultiResult, error) {
 
-	if sel == "" {
-		return nil, errNilSelector
-	}
-	query := DataSelector{
-		Selector: []byte(sel),
-		Fields:   fld,
+	query, err := db.newQuery(sel, fld, opt)
+	if err != nil {
+		return nil, err
 	}
 
-	db.setFindOptions(&query, opt)
-
 	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
 
 	endpoint := fmt.Sprintf("%s/%s", db.Name, endPointFind)
 	rqb := request.NewRequestBuilder()
 
-	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodPost).WithBody(body).Build(db.cli)
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodPost).WithBody(body).WithIdempotent(true).Build(db.cli)
 
 	if err != nil {
 		return nil, err
 	}
 
 	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = response.CheckStatus(&rs, endpoint); err != nil {
+		rs.Rdr.Close()
+		return response.NewMultiResult(rs.CouchStatus, &errorCursor{err: err}), err
+	}
+
+	var crsr cursor.ResultCursor
+	warn := db.warningHandler(ctx, query)
 
-	if rs.Code >= response.StatusCode400BadRequest {
-		err = errors.New(rs.Status)
+	switch {
+	case query.Prefetch > 0:
+		crsr = newPrefetchCursor(ctx, rs.Rdr, endpoint, query, db.cli, warn, query.Prefetch)
+	case query.Stream:
+		crsr = newStreamCursor(rs.Rdr, endpoint, query, db.cli, warn)
+	default:
+		crsr = newBufferedCursor(rs.Rdr, endpoint, query, db.cli, warn)
 	}
 
-	return response.NewMultiResult(rs.CouchStatus, newBufferedCursor(rs.Rdr, endpoint, query, db.cli)), err
+	//a warning of a streamed page is known only after the page was read, so it is reported by Err
+	return response.NewMultiResult(rs.CouchStatus, crsr), crsr.Err()
+}
+
+//Select - Selects documents from the database and returns a cursor that decodes them into values of type T, see CouchDatabase.Select
+func Select[T any](ctx context.Context, db *CouchDatabase, sel interface{}, fld []string, opt map[FindOption]interface{}) (*cursor.Cursor[T], error) {
+
+	result, err := db.Select(ctx, sel, fld, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	return cursor.NewCursor[T](result.ResultCursor), nil
 }
 
 //Insert - Inserts a new document to databsase
@@ -180,11 +208,12 @@ func (db *CouchDatabase) Insert(ctx context.Context, doc interface{}) (*response
 		return nil, err
 	}
 	rs, err := rq.Execute(ctx)
-
-	if rs.Code >= response.StatusCode400BadRequest {
-		err = errors.New(rs.Status)
+	if err != nil {
+		return nil, err
 	}
 
+	err = response.CheckStatus(&rs, endpoint)
+
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 
 }
@@ -214,11 +243,12 @@ func (db *CouchDatabase) InsertMany(ctx context.Context, docs []interface{}) (*r
 	}
 
 	rs, err := rq.Execute(ctx)
-
-	if rs.Code >= response.StatusCode400BadRequest {
-		err = errors.New(rs.Status)
+	if err != nil {
+		return nil, err
 	}
 
+	err = response.CheckStatus(&rs, endpoint)
+
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 }
 
@@ -235,6 +265,11 @@ func (db *CouchDatabase) Revision(ctx context.Context, id string) (*response.Cou
 	}
 
 	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endpoint)
 
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 }
@@ -266,11 +301,12 @@ func (db *CouchDatabase) Update(ctx context.Context, doc interface{}) (*response
 	}
 
 	rs, err := rq.Execute(ctx)
-
-	if rs.Code >= response.StatusCode400BadRequest {
-		err = errors.New(rs.Status)
+	if err != nil {
+		return nil, err
 	}
 
+	err = response.CheckStatus(&rs, endpoint)
+
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 }
 
@@ -293,11 +329,12 @@ func (db *CouchDatabase) Delete(ctx context.Context, id, rev string) (*response.
 	}
 
 	rs, err := rq.Execute(ctx)
-
-	if rs.Code >= response.StatusCode400BadRequest {
-		err = errors.New(rs.Status)
+	if err != nil {
+		return nil, err
 	}
 
+	err = response.CheckStatus(&rs, endpoint)
+
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 
 }
@@ -331,11 +368,12 @@ func (db *CouchDatabase) Copy(ctx context.Context, id, destID, destREV string) (
 	}
 
 	rs, err := rq.Execute(ctx)
-
-	if rs.Code >= response.StatusCode400BadRequest {
-		err = errors.New(rs.Status)
+	if err != nil {
+		return nil, err
 	}
 
+	err = response.CheckStatus(&rs, endpoint)
+
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 
 }
@@ -368,42 +406,170 @@ func (db *CouchDatabase) Purge(ctx context.Context, id string, rev []string) (*r
 	}
 
 	rs, err := rq.Execute(ctx)
-
-	if rs.Code >= response.StatusCode400BadRequest {
-		err = errors.New(rs.Status)
+	if err != nil {
+		return nil, err
 	}
 
+	err = response.CheckStatus(&rs, endpoint)
+
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 }
 
-func (db *CouchDatabase) setFindOptions(s *DataSelector, opt map[FindOption]interface{}) {
+//newQuery - creates a body of a _find and _explain request
+func (db *CouchDatabase) newQuery(sel interface{}, fld []string, opt map[FindOption]interface{}) (DataSelector, error) {
+
+	data, err := selectorData(sel)
+	if err != nil {
+		return DataSelector{}, err
+	}
+
+	query := DataSelector{
+		Selector: data,
+		Fields:   fld,
+	}
+
+	if err := db.setFindOptions(&query, opt); err != nil {
+		return DataSelector{}, err
+	}
+
+	return query, nil
+}
+
+//setFindOptions - sets options of a query, returns an error if a value has an unexpected type or is invalid
+func (db *CouchDatabase) setFindOptions(s *DataSelector, opt map[FindOption]interface{}) error {
 
 	for k, v := range opt {
+
+		valid := true
+
 		switch k {
 		case OptionBookmark:
 			{
-				if val, ok := v.(string); ok {
-					s.Bookmark = val
-				}
+				s.Bookmark, valid = v.(string)
 			}
 		case OptionLimit:
 			{
-				if val, ok := v.(int); ok {
-					s.Limit = val
-				}
+				s.Limit, valid = v.(int)
+				valid = valid && s.Limit >= 0
+			}
+		case OptionSkip:
+			{
+				s.Skip, valid = v.(int)
+				valid = valid && s.Skip >= 0
+			}
+		case OptionR:
+			{
+				s.R, valid = v.(int)
+				valid = valid && s.R > 0
 			}
 		case OptionStat:
 			{
-				if val, ok := v.(bool); ok {
-					s.Stats = val
-				}
+				s.Stats, valid = v.(bool)
+			}
+		case OptionConflicts:
+			{
+				s.Conflicts, valid = v.(bool)
+			}
+		case OptionStable:
+			{
+				s.Stable, valid = v.(bool)
+			}
+		case OptionStream:
+			{
+				s.Stream, valid = v.(bool)
+			}
+		case OptionPrefetch:
+			{
+				s.Prefetch, valid = v.(int)
+				valid = valid && s.Prefetch >= 0
+			}
+		case OptionUpdate:
+			{
+				var val bool
+				val, valid = v.(bool)
+				s.Update = &val
 			}
 		case OptionIndex:
 			{
-				if val, ok := v.(string); ok {
+				switch val := v.(type) {
+				case string:
+					s.Index = []string{val}
+				case []string:
 					s.Index = val
+				case [2]string:
+					s.Index = val[:]
+				default:
+					valid = false
 				}
+				valid = valid && len(s.Index) > 0 && len(s.Index) <= 2
 			}
+		case OptionSort:
+			{
+				sort, err := sortFields(v)
+				if err != nil {
+					return err
+				}
+				s.Sort = sort
+			}
+		default:
+			{
+				valid = false
+			}
+		}
+
+		if !valid {
+			return fmt.Errorf("%w: %s", errInvalidFindOption, k)
 		}
 	}
+
+	if s.Stream && s.Prefetch > 0 {
+		return errStreamPrefetch
+	}
+
+	return nil
+}
+
+//sortFields - converts and validates a value of the sort option
+func sortFields(v interface{}) ([]SortField, error) {
+
+	var fields []SortField
+
+	switch val := v.(type) {
+	case []SortField:
+		fields = append(fields, val...)
+	case []string:
+		for _, f := range val {
+			fields = append(fields, SortField{Field: f, Direction: SortAsc})
+		}
+	case []map[string]string:
+		for _, m := range val {
+			if len(m) != 1 {
+				return nil, errInvalidSort
+			}
+			for f, d := range m {
+				fields = append(fields, SortField{Field: f, Direction: d})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", errInvalidFindOption, OptionSort)
+	}
+
+	direction := ""
+	for i := range fields {
+
+		if fields[i].Direction == "" {
+			fields[i].Direction = SortAsc
+		}
+
+		if fields[i].Field == "" || (fields[i].Direction != SortAsc && fields[i].Direction != SortDesc) {
+			return nil, errInvalidSort
+		}
+
+		if direction != "" && direction != fields[i].Direction {
+			return nil, errMixedSortDirection
+		}
+		direction = fields[i].Direction
+	}
+
+	return fields, nil
 }
@@ -1,11 +1,25 @@
 package database
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/przebro/couchdb/cursor"
 	"github.com/przebro/couchdb/response"
+	"github.com/przebro/couchdb/selector"
 
 	"github.com/przebro/couchdb/client"
 	"github.com/przebro/couchdb/connection"
@@ -185,8 +199,8 @@ func TestRevision(t *testing.T) {
 
 	result, err = database.Revision(context.Background(), "test_document_id_03")
 
-	if err != nil {
-		t.Error("unexpected result")
+	if !errors.Is(err, response.ErrNotFound) {
+		t.Error("unexpected result:", err)
 	}
 
 	if result.Code != 404 {
@@ -659,6 +673,1381 @@ func TestSetMemberSecurity(t *testing.T) {
 
 }
 
+func TestAttachment(t *testing.T) {
+
+	_, db, err := GetDatabsase(context.Background(), database, conn.GetClient())
+	if err != nil {
+		t.Error(err)
+	}
+
+	resmsg := struct {
+		Ok  bool   `json:"ok"`
+		ID  string `json:"id"`
+		Rev string `json:"rev"`
+	}{}
+
+	content := "%PDF-1.4 scanned document"
+
+	_, err = db.PutAttachment(context.Background(), "attachment_document_01", "", "", "application/pdf", strings.NewReader(content))
+	if err != errEmptyAttachmentName {
+		t.Error("unexpected result:", err)
+	}
+
+	result, err := db.PutAttachment(context.Background(), "attachment_document_01", "", "scan.pdf", "application/pdf", strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Code != 201 {
+		t.Error("unexpected result:", result.Code)
+	}
+
+	result.Decode(&resmsg)
+
+	_, err = db.PutAttachment(context.Background(), "attachment_document_01", "", "other.pdf", "application/pdf", strings.NewReader(content))
+	if !errors.Is(err, response.ErrConflict) {
+		t.Error("unexpected result:", err)
+	}
+
+	buf := &bytes.Buffer{}
+	info, err := db.GetAttachment(context.Background(), "attachment_document_01", "", "scan.pdf", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != content || info.ContentType != "application/pdf" || !strings.HasPrefix(info.Digest, "md5-") {
+		t.Error("unexpected result:", buf.String(), info.ContentType, info.Digest)
+	}
+
+	buf.Reset()
+	info, err = db.GetAttachmentRange(context.Background(), "attachment_document_01", "", "scan.pdf", buf, 0, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Code != 206 || buf.String() != "%PDF-1.4" {
+		t.Error("unexpected result:", info.Code, buf.String())
+	}
+
+	_, err = db.GetAttachment(context.Background(), "attachment_document_01", "", "missing.pdf", buf)
+	if !errors.Is(err, response.ErrNotFound) {
+		t.Error("unexpected result:", err)
+	}
+
+	result, err = db.DeleteAttachment(context.Background(), "attachment_document_01", resmsg.Rev, "scan.pdf")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if result.Code != 200 {
+		t.Error("unexpected result:", result.Code)
+	}
+}
+
+func TestAttachmentEncoding(t *testing.T) {
+
+	content := "plain text attachment, plain text attachment, plain text attachment"
+	compressed := &bytes.Buffer{}
+	gz := gzip.NewWriter(compressed)
+	gz.Write([]byte(content))
+	gz.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"rL0Y20zC+Fzt72VPzMSk2A=="`)
+
+		//the attachment is compressed only if the client accepts it
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write([]byte(content))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", fmt.Sprint(compressed.Len()))
+		w.Write(compressed.Bytes())
+	}))
+	defer srv.Close()
+
+	db := &CouchDatabase{Name: "movies", cli: &client.CouchClient{BaseAddr: srv.URL, Authentication: client.None, Client: srv.Client()}}
+
+	buf := &bytes.Buffer{}
+	info, err := db.GetAttachment(context.Background(), "movie_1", "", "notes.txt", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != content || info.Encoding != "gzip" || info.Length != int64(compressed.Len()) || info.Digest != "md5-rL0Y20zC+Fzt72VPzMSk2A==" {
+		t.Error("unexpected result:", buf.String(), info.Encoding, info.Length)
+	}
+}
+
+func TestMultipartLength(t *testing.T) {
+
+	atts := []Attachment{
+		{Name: "a.txt", ContentType: "text/plain", Length: 5, Body: strings.NewReader("abcde")},
+		{Name: "b.bin", ContentType: defaultContentType, Length: 3, Body: strings.NewReader("xyz")},
+	}
+
+	data, err := multipartDocument(&SampleDoc{ID: "doc"}, atts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stubs := struct {
+		Attachments map[string]attachmentStub `json:"_attachments"`
+	}{}
+	json.Unmarshal(data, &stubs)
+
+	if len(stubs.Attachments) != 2 || !stubs.Attachments["a.txt"].Follows || stubs.Attachments["b.bin"].Length != 3 {
+		t.Error("unexpected result:", string(data))
+	}
+
+	length, err := multipartLength("boundary", data, atts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err = writeMultipart(buf, "boundary", data, atts); err != nil {
+		t.Fatal(err)
+	}
+
+	if int64(buf.Len()) != length {
+		t.Error("unexpected result, expected:", buf.Len(), "actual:", length)
+	}
+}
+
+func TestMultipartDocument(t *testing.T) {
+
+	_, db, err := GetDatabsase(context.Background(), database, conn.GetClient())
+	if err != nil {
+		t.Error(err)
+	}
+
+	doc := SampleDoc{ID: "multipart_document_01", Name: "Scanned", Group: "group_1"}
+	atts := []Attachment{
+		{Name: "page_2.txt", ContentType: "text/plain", Length: 6, Body: strings.NewReader("page 2")},
+		{Name: "page_1.txt", ContentType: "text/plain", Length: 6, Body: strings.NewReader("page 1")},
+	}
+
+	result, err := db.InsertWithAttachments(context.Background(), &doc, atts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Code != 201 {
+		t.Error("unexpected result:", result.Code)
+	}
+
+	mdoc, err := db.GetWithAttachments(context.Background(), "multipart_document_01", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mdoc.Close()
+
+	rdoc := SampleDoc{}
+	if err = mdoc.Decode(&rdoc); err != nil || rdoc.Name != "Scanned" {
+		t.Error("unexpected result:", err)
+	}
+
+	pages := map[string]string{}
+	for {
+		att, err := mdoc.NextAttachment()
+		if err != nil {
+			break
+		}
+		data, _ := ioutil.ReadAll(att)
+		pages[att.Name] = string(data)
+	}
+
+	if pages["page_1.txt"] != "page 1" || pages["page_2.txt"] != "page 2" {
+		t.Error("unexpected result:", pages)
+	}
+}
+
+func TestView(t *testing.T) {
+
+	_, db, err := GetDatabsase(context.Background(), database, conn.GetClient())
+	if err != nil {
+		t.Error(err)
+	}
+
+	ddoc := struct {
+		ID    string                       `json:"_id"`
+		Views map[string]map[string]string `json:"views"`
+	}{
+		ID: "_design/movies",
+		Views: map[string]map[string]string{
+			"by_year": {
+				"map":    "function(doc){ if(doc.year){ emit(doc.year, doc.title); } }",
+				"reduce": "_count",
+			},
+		},
+	}
+
+	if _, err = db.Insert(context.Background(), &ddoc); err != nil {
+		t.Fatal(err)
+	}
+
+	reduce := false
+	result, err := db.View(context.Background(), "movies", "by_year", ViewOptions{Key: 1980, Reduce: &reduce, IncludeDocs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	titles := []string{}
+	for result.Next() {
+		row := result.Row()
+		doc := TestDocument{}
+		if err = row.DecodeDoc(&doc); err != nil {
+			t.Error(err)
+		}
+		titles = append(titles, doc.Title)
+	}
+	result.Close()
+
+	if result.Err() != nil || len(titles) != 2 || result.TotalRows == 0 {
+		t.Error("unexpected result:", result.Err(), titles)
+	}
+
+	result, err = db.View(context.Background(), "_design/movies", "by_year", ViewOptions{Keys: []interface{}{1980, 1986}, Group: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+
+	counts := map[int]int{}
+	for result.Next() {
+		row := result.Row()
+		var year, count int
+		row.DecodeKey(&year)
+		row.DecodeValue(&count)
+		counts[year] = count
+	}
+
+	if counts[1980] != 2 || counts[1986] != 2 {
+		t.Error("unexpected result:", counts)
+	}
+
+	_, err = db.View(context.Background(), "movies", "by_year", ViewOptions{Key: 1980, Keys: []interface{}{1986}})
+	if err != errInvalidViewOpt {
+		t.Error("unexpected result:", err)
+	}
+
+	_, err = db.View(context.Background(), "movies", "not_exists", ViewOptions{})
+	if !errors.Is(err, response.ErrNotFound) {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestLoadDesign(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"design/reports/views/by_year/map.js":    {Data: []byte("function(doc){ emit(doc.year, 1); }\n")},
+		"design/reports/views/by_year/reduce.js": {Data: []byte("_sum")},
+		"design/reports/filters/oscars.js":       {Data: []byte("function(doc, req){ return doc.oscars; }")},
+		"design/reports/validate_doc_update.js":  {Data: []byte("function(newDoc, oldDoc, userCtx){}")},
+		"design/reports/options.json":            {Data: []byte(`{"partitioned":false}`)},
+		"design/broken/views/by_year/reduce.js":  {Data: []byte("_sum")},
+	}
+
+	ddoc, err := LoadDesign(fsys, "design/reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ddoc.ID != "_design/reports" || ddoc.Views["by_year"].Map != "function(doc){ emit(doc.year, 1); }" || ddoc.Views["by_year"].Reduce != "_sum" {
+		t.Error("unexpected result:", ddoc)
+	}
+
+	if ddoc.Filters["oscars"] == "" || ddoc.ValidateDocUpdate == "" || ddoc.Updates != nil {
+		t.Error("unexpected result:", ddoc)
+	}
+
+	if ddoc.Options == nil || ddoc.Options.Partitioned == nil || *ddoc.Options.Partitioned {
+		t.Error("unexpected result:", ddoc.Options)
+	}
+
+	_, err = LoadDesign(fsys, "design/broken")
+	if !errors.Is(err, errMissingMapFunc) {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestSyncDesign(t *testing.T) {
+
+	var mu sync.Mutex
+	puts := 0
+	stored := map[string]interface{}{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+			if len(stored) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"not_found","reason":"missing"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(stored)
+		case http.MethodPut:
+			puts++
+			stored = map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&stored)
+			stored["_rev"] = fmt.Sprintf("%d-a", puts)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"ok":true,"id":"_design/stats","rev":"%d-a"}`, puts)
+		}
+	}))
+	defer srv.Close()
+
+	db := &CouchDatabase{Name: "movies", cli: &client.CouchClient{BaseAddr: srv.URL, Authentication: client.None, Client: srv.Client()}}
+
+	ddoc := &DesignDocument{ID: "stats", Views: map[string]ViewDefinition{"by_score": {Map: "function(doc){ emit(doc.score, null); }"}}}
+	changed, err := db.SyncDesign(context.Background(), ddoc)
+	if err != nil || !changed || ddoc.Rev != "1-a" {
+		t.Fatal("unexpected result:", changed, err)
+	}
+
+	//members that are not defined by the DesignDocument are added by someone else
+	mu.Lock()
+	stored["shows"] = map[string]interface{}{"summary": "function(doc, req){ return doc.title; }"}
+	stored["x_owner"] = "reports"
+	stored["views"].(map[string]interface{})["by_score"].(map[string]interface{})["options"] = map[string]interface{}{"collation": "raw"}
+	mu.Unlock()
+
+	changed, err = db.SyncDesign(context.Background(), &DesignDocument{ID: "_design/stats", Views: ddoc.Views})
+	if err != nil || changed || puts != 1 {
+		t.Error("unexpected result:", changed, err, puts)
+	}
+
+	ddoc.Filters = map[string]string{"oscars": "function(doc, req){ return doc.oscars; }"}
+	changed, err = db.SyncDesign(context.Background(), ddoc)
+	if err != nil || !changed || ddoc.Rev != "2-a" {
+		t.Fatal("unexpected result:", changed, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	view := stored["views"].(map[string]interface{})["by_score"].(map[string]interface{})
+	if stored["shows"] == nil || stored["x_owner"] != "reports" || view["options"] == nil || view["map"] != ddoc.Views["by_score"].Map {
+		t.Error("unexpected result:", stored)
+	}
+
+	if stored["filters"].(map[string]interface{})["oscars"] != ddoc.Filters["oscars"] {
+		t.Error("unexpected result:", stored)
+	}
+}
+
+func TestDesignDocument(t *testing.T) {
+
+	_, db, err := GetDatabsase(context.Background(), database, conn.GetClient())
+	if err != nil {
+		t.Error(err)
+	}
+
+	ddoc := &DesignDocument{
+		ID:    "stats",
+		Views: map[string]ViewDefinition{"by_score": {Map: "function(doc){ emit(doc.score, null); }"}},
+	}
+
+	changed, err := db.SyncDesign(context.Background(), ddoc)
+	if err != nil || !changed || ddoc.Rev == "" {
+		t.Fatal("unexpected result:", changed, err)
+	}
+
+	changed, err = db.SyncDesign(context.Background(), &DesignDocument{ID: "_design/stats", Views: ddoc.Views})
+	if err != nil || changed {
+		t.Error("unexpected result:", changed, err)
+	}
+
+	ddoc.Filters = map[string]string{"oscars": "function(doc, req){ return doc.oscars; }"}
+	changed, err = db.SyncDesign(context.Background(), ddoc)
+	if err != nil || !changed {
+		t.Error("unexpected result:", changed, err)
+	}
+
+	stored, err := db.GetDesign(context.Background(), "stats")
+	if err != nil || stored.Rev != ddoc.Rev || stored.Filters["oscars"] == "" {
+		t.Error("unexpected result:", stored, err)
+	}
+
+	_, err = db.PutDesign(context.Background(), &DesignDocument{ID: "stats", Views: ddoc.Views})
+	if !errors.Is(err, response.ErrConflict) {
+		t.Error("unexpected result:", err)
+	}
+
+	if _, err = db.DeleteDesign(context.Background(), "stats", ddoc.Rev); err != nil {
+		t.Error(err)
+	}
+
+	_, err = db.GetDesign(context.Background(), "stats")
+	if !errors.Is(err, response.ErrNotFound) {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestIndexField(t *testing.T) {
+
+	data, err := json.Marshal([]IndexField{{Name: "year"}, {Name: "score", Direction: SortDesc}, {Name: "title", Type: "string"}})
+	if err != nil || string(data) != `["year",{"score":"desc"},{"name":"title","type":"string"}]` {
+		t.Error("unexpected result:", string(data), err)
+	}
+
+	fields := []IndexField{}
+	err = json.Unmarshal([]byte(`["year",{"score":"desc"},{"title":"string"}]`), &fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []IndexField{{Name: "year", Direction: SortAsc}, {Name: "score", Direction: SortDesc}, {Name: "title", Type: "string"}}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Error("unexpected result:", fields[i])
+		}
+	}
+
+	def := IndexDefinition{Fields: []IndexField{{Name: "year", Direction: "up"}}}
+	if err = def.validate(); err != errInvalidDirection {
+		t.Error("unexpected result:", err)
+	}
+
+	def = IndexDefinition{Type: IndexTypeJSON}
+	if err = def.validate(); err != errEmptyIndexFields {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+
+	_, db, err := GetDatabsase(context.Background(), database, conn.GetClient())
+	if err != nil {
+		t.Error(err)
+	}
+
+	defs := []IndexDefinition{
+		{DDoc: "movies_idx", Name: "by_year", Type: IndexTypeJSON, Fields: []IndexField{{Name: "year"}}},
+		{DDoc: "movies_idx", Name: "oscars_by_score", Fields: []IndexField{{Name: "score", Direction: SortDesc}},
+			PartialFilterSelector: json.RawMessage(`{"oscars":true}`)},
+	}
+
+	results, err := db.EnsureIndexes(context.Background(), defs...)
+	if err != nil || len(results) != 2 {
+		t.Fatal("unexpected result:", results, err)
+	}
+
+	results, err = db.EnsureIndexes(context.Background(), defs...)
+	if err != nil || results[0].Result != IndexExists || results[1].Result != IndexExists {
+		t.Error("unexpected result:", results, err)
+	}
+
+	indexes, err := db.GetIndex(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]IndexDefinition{}
+	for _, idx := range indexes {
+		found[idx.Name] = idx
+	}
+
+	if found["by_year"].DDoc != "_design/movies_idx" || found["by_year"].Fields[0].Name != "year" {
+		t.Error("unexpected result:", found["by_year"])
+	}
+
+	if found["oscars_by_score"].Fields[0].Direction != SortDesc || found["oscars_by_score"].PartialFilterSelector == nil {
+		t.Error("unexpected result:", found["oscars_by_score"])
+	}
+
+	if _, ok := found["_all_docs"]; !ok {
+		t.Error("unexpected result:", indexes)
+	}
+
+	if _, err = db.DeleteIndex(context.Background(), "movies_idx", IndexTypeJSON, "oscars_by_score"); err != nil {
+		t.Error(err)
+	}
+
+	_, err = db.DeleteIndex(context.Background(), "movies_idx", IndexTypeJSON, "oscars_by_score")
+	if !errors.Is(err, response.ErrNotFound) {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestExplainPlan(t *testing.T) {
+
+	data := `{"dbname":"movies",
+	"index":{"ddoc":"_design/movies_idx","name":"by_year","type":"json","partitioned":false,"def":{"fields":[{"year":"asc"}]}},
+	"partitioned":false,
+	"selector":{"year":{"$gt":1980}},
+	"opts":{"use_index":[],"bookmark":"nil","limit":2,"skip":0,"sort":{},"fields":["title"],"partition":"","r":[49],
+		"conflicts":false,"stale":false,"update":true,"stable":false,"execution_stats":false},
+	"limit":2,"skip":0,"fields":["title"],
+	"mrargs":{"include_docs":true,"view_type":"map","reduce":false,"start_key":[1980],"end_key":["<MAX>"],"direction":"fwd","stable":false,"update":"true"},
+	"index_candidates":[{"index":{"ddoc":null,"name":"_all_docs","type":"special","def":{"fields":[{"_id":"asc"}]}},
+		"analysis":{"usable":true,"reasons":[{"name":"unfavored_type"}],"ranking":1,"covering":null}}]}`
+
+	er := explainResult{}
+	if err := json.Unmarshal([]byte(data), &er); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := newExplainPlan(er)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan.FullScan() || plan.Index.Name != "by_year" || plan.Index.Fields[0].Name != "year" || plan.Limit != 2 {
+		t.Error("unexpected result:", plan)
+	}
+
+	if string(plan.Range.StartKey) != "[1980]" || plan.Range.Direction != "fwd" || len(plan.Fields) != 1 || !plan.Options.Update {
+		t.Error("unexpected result:", plan.Range, plan.Fields)
+	}
+
+	if len(plan.Candidates) != 1 || plan.Candidates[0].Index.Type != IndexTypeSpecial || plan.Candidates[0].Reasons[0] != "unfavored_type" {
+		t.Error("unexpected result:", plan.Candidates)
+	}
+
+	er.Fields = json.RawMessage(`"all_fields"`)
+	if plan, err = newExplainPlan(er); err != nil || plan.Fields != nil {
+		t.Error("unexpected result:", plan.Fields, err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+
+	_, db, err := GetDatabsase(context.Background(), database, conn.GetClient())
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = db.Index(context.Background(), IndexDefinition{DDoc: "explain_idx", Name: "by_title", Fields: []IndexField{{Name: "title"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := db.Explain(context.Background(), `{"title":{"$gt":"T"}}`, []string{"title"}, map[FindOption]interface{}{OptionLimit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan.FullScan() || plan.Index.Name != "by_title" || plan.Limit != 3 || plan.DB != database {
+		t.Error("unexpected result:", plan)
+	}
+
+	plan, err = db.Explain(context.Background(), `{"oscars":true}`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !plan.FullScan() {
+		t.Error("unexpected result:", plan.Index)
+	}
+
+	if _, err = db.Explain(context.Background(), "", nil, nil); err != errNilSelector {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestFindOptions(t *testing.T) {
+
+	db := &CouchDatabase{}
+
+	query, err := db.newQuery(`{"year":{"$gt":1980}}`, []string{"title"}, map[FindOption]interface{}{
+		OptionSort:      []map[string]string{{"year": "desc"}, {"title": "desc"}},
+		OptionSkip:      2,
+		OptionLimit:     5,
+		OptionR:         1,
+		OptionConflicts: true,
+		OptionUpdate:    false,
+		OptionStable:    true,
+		OptionIndex:     []string{"movies_idx", "by_year"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(query)
+	expected := `{"selector":{"year":{"$gt":1980}},"fields":["title"],"sort":[{"year":"desc"},{"title":"desc"}],"limit":5,"skip":2,` +
+		`"use_index":["movies_idx","by_year"],"r":1,"conflicts":true,"update":false,"stable":true}`
+	if string(data) != expected {
+		t.Error("unexpected result:", string(data))
+	}
+
+	query, err = db.newQuery("{}", nil, map[FindOption]interface{}{OptionSort: []string{"year"}, OptionIndex: "movies_idx"})
+	if err != nil || query.Sort[0].Direction != SortAsc || len(query.Index) != 1 {
+		t.Error("unexpected result:", query, err)
+	}
+
+	invalid := []map[FindOption]interface{}{
+		{OptionLimit: "10"},
+		{OptionSkip: -1},
+		{OptionIndex: []string{}},
+		{OptionSort: "year"},
+		{FindOption("unknown"): true},
+	}
+
+	for _, opt := range invalid {
+		if _, err = db.newQuery("{}", nil, opt); !errors.Is(err, errInvalidFindOption) {
+			t.Error("unexpected result:", opt, err)
+		}
+	}
+
+	_, err = db.newQuery("{}", nil, map[FindOption]interface{}{OptionSort: []map[string]string{{"year": "up"}}})
+	if err != errInvalidSort {
+		t.Error("unexpected result:", err)
+	}
+
+	_, err = db.newQuery("{}", nil, map[FindOption]interface{}{OptionSort: []map[string]string{{"year": "asc", "title": "asc"}}})
+	if err != errInvalidSort {
+		t.Error("unexpected result:", err)
+	}
+
+	_, err = db.newQuery("{}", nil, map[FindOption]interface{}{OptionSort: []SortField{{Field: "year"}, {Field: "title", Direction: SortDesc}}})
+	if err != errMixedSortDirection {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestSortedSelect(t *testing.T) {
+
+	_, db, err := GetDatabsase(context.Background(), database, conn.GetClient())
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = db.Index(context.Background(), IndexDefinition{DDoc: "sort_idx", Name: "by_year", Fields: []IndexField{{Name: "year", Direction: SortDesc}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.Select(context.Background(), `{"year":{"$gt":0}}`, []string{"year"}, map[FindOption]interface{}{
+		OptionSort:  []SortField{{Field: "year", Direction: SortDesc}},
+		OptionIndex: []string{"sort_idx", "by_year"},
+		OptionSkip:  1,
+		OptionLimit: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []TestDocument{}
+	for result.Next(context.Background()) {
+		doc := TestDocument{}
+		result.Decode(&doc)
+		docs = append(docs, doc)
+		if len(docs) == 3 {
+			break
+		}
+	}
+
+	if len(docs) != 3 || docs[0].Year < docs[1].Year || docs[1].Year < docs[2].Year {
+		t.Error("unexpected result:", docs)
+	}
+}
+
+func TestSelectorData(t *testing.T) {
+
+	valid := []interface{}{
+		`{"year":1980}`,
+		[]byte(` {"year":1980}`),
+		json.RawMessage(`{"year":1980}`),
+		selector.Eq("year", 1980),
+	}
+
+	for _, sel := range valid {
+		data, err := selectorData(sel)
+		if err != nil || !strings.Contains(string(data), "1980") {
+			t.Error("unexpected result:", string(data), err)
+		}
+	}
+
+	invalid := map[error][]interface{}{
+		errNilSelector:         {nil, "", []byte{}},
+		errInvalidSelector:     {`["year"]`, `{"year":`},
+		errSelectorKind:        {10, map[string]int{"year": 1980}},
+		selector.ErrEmptyField: {selector.Eq("", 1980)},
+	}
+
+	for expected, sels := range invalid {
+		for _, sel := range sels {
+			if _, err := selectorData(sel); !errors.Is(err, expected) {
+				t.Error("unexpected result:", sel, err)
+			}
+		}
+	}
+}
+
+func TestSelectWithBuilder(t *testing.T) {
+
+	_, db, err := GetDatabsase(context.Background(), database, conn.GetClient())
+	if err != nil {
+		t.Error(err)
+	}
+
+	sel := selector.And(
+		selector.Eq("oscars", true),
+		selector.Gte("year", 1980),
+		selector.Regex("title", "^(Star|Platoon)"),
+	)
+
+	result, err := db.Select(context.Background(), sel, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []TestDocument{}
+	if err = result.All(context.Background(), &docs); err != nil {
+		t.Error(err)
+	}
+
+	if len(docs) != 2 {
+		t.Error("unexpected result:", docs)
+	}
+}
+
+func TestTypedSelect(t *testing.T) {
+
+	_, db, err := GetDatabsase(context.Background(), database, conn.GetClient())
+	if err != nil {
+		t.Error(err)
+	}
+
+	crsr, err := Select[TestDocument](context.Background(), &db, selector.Eq("year", 1980), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer crsr.Close(context.Background())
+
+	movies, err := crsr.All(context.Background())
+	if err != nil || len(movies) != 2 || movies[0].Year != 1980 {
+		t.Error("unexpected result:", movies, err)
+	}
+
+	wrong, err := Select[struct {
+		Year string `json:"year"`
+	}](context.Background(), &db, selector.Eq("year", 1980), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = wrong.All(context.Background()); err == nil {
+		t.Error("unexpected result, expected a decode error")
+	}
+}
+
+func TestCursorErrors(t *testing.T) {
+
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Write([]byte(`{"docs":[{"title":"Alien"}],"bookmark":"page_2"}`))
+		case 2:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_bookmark","reason":"Invalid bookmark value"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not_found","reason":"Database does not exist."}`))
+		}
+	}))
+	defer srv.Close()
+
+	db := &CouchDatabase{Name: "movies", cli: &client.CouchClient{BaseAddr: srv.URL, Authentication: client.None, Client: srv.Client()}}
+
+	result, err := db.Select(context.Background(), "{}", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []TestDocument{}
+	err = result.All(context.Background(), &docs)
+	if !errors.Is(err, response.ErrBadRequest) || !errors.Is(result.Err(), response.ErrBadRequest) || len(docs) != 1 {
+		t.Error("unexpected result:", docs, err)
+	}
+
+	if result.Next(context.Background()) {
+		t.Error("unexpected result, cursor should stay in the error state")
+	}
+
+	result, err = db.Select(context.Background(), "{}", nil, nil)
+	if !errors.Is(err, response.ErrNotFound) || result == nil || result.ResultCursor == nil {
+		t.Fatal("unexpected result:", err)
+	}
+
+	if result.Next(context.Background()) || !errors.Is(result.Err(), response.ErrNotFound) {
+		t.Error("unexpected result:", result.Err())
+	}
+
+	crsr := newBufferedCursor(ioutil.NopCloser(strings.NewReader("{")), "movies/_find", DataSelector{}, nil, nil)
+	if crsr == nil || crsr.Next(context.Background()) || crsr.Err() == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestStreamCursor(t *testing.T) {
+
+	pages := []string{
+		`{"docs":[{"title":"Alien","year":1979},{"title":"Aliens","year":1986}],"bookmark":"page_2","warning":"No matching index found","execution_stats":{"results_returned":2}}`,
+		`{"bookmark":"page_3","docs":[{"title":"Alien 3","year":1992}]}`,
+		`{"docs":[],"bookmark":"page_4"}`,
+	}
+
+	bookmarks := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sel := DataSelector{}
+		json.NewDecoder(r.Body).Decode(&sel)
+		bookmarks = append(bookmarks, sel.Bookmark)
+		w.Write([]byte(pages[len(bookmarks)-1]))
+	}))
+	defer srv.Close()
+
+	db := &CouchDatabase{Name: "movies", cli: &client.CouchClient{BaseAddr: srv.URL, Authentication: client.None, Client: srv.Client()}}
+
+	result, err := db.Select(context.Background(), "{}", nil, map[FindOption]interface{}{OptionStream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := TestDocument{}
+	if !result.Next(context.Background()) || result.Decode(&doc) != nil || doc.Title != "Alien" {
+		t.Fatal("unexpected result:", doc)
+	}
+
+	//a second call to next without decode moves to the next document
+	if !result.Next(context.Background()) || !result.Next(context.Background()) {
+		t.Fatal("unexpected result:", result.Err())
+	}
+
+	if result.Decode(&doc) != nil || doc.Title != "Alien 3" || result.Meta().Bookmark != "page_2" {
+		t.Error("unexpected result:", doc, result.Meta())
+	}
+
+	if result.Next(context.Background()) || result.Err() != nil {
+		t.Error("unexpected result:", result.Err())
+	}
+
+	if meta := result.Meta(); meta.Bookmark != "page_4" || meta.Documents != 2 || meta.Warning != "" {
+		t.Error("unexpected result:", meta)
+	}
+
+	if len(bookmarks) != 3 || bookmarks[0] != "" || bookmarks[1] != "page_2" || bookmarks[2] != "page_3" {
+		t.Error("unexpected result:", bookmarks)
+	}
+
+	crsr := newStreamCursor(ioutil.NopCloser(strings.NewReader(`{"docs":[{"title":"Alien"},{"title"`)), "movies/_find", DataSelector{}, nil, nil)
+	if !crsr.Next(context.Background()) || crsr.Next(context.Background()) || crsr.Err() == nil {
+		t.Error("unexpected result:", crsr.Err())
+	}
+
+	crsr = newStreamCursor(ioutil.NopCloser(strings.NewReader(`{"docs":[{"title":"Alien"}],"bookmark":"b","warning":"no index","execution_stats":{"results_returned":1}}`)), "movies/_find", DataSelector{}, nil, nil)
+	docs := []TestDocument{}
+	if err = crsr.All(context.Background(), &docs); err != nil || len(docs) != 1 {
+		t.Error("unexpected result:", docs, err)
+	}
+
+	if meta := crsr.Meta(); meta.Bookmark != "b" || meta.Warning != "no index" || meta.Documents != 1 {
+		t.Error("unexpected result:", meta)
+	}
+}
+
+func TestPrefetchCursor(t *testing.T) {
+
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sel := DataSelector{}
+		json.NewDecoder(r.Body).Decode(&sel)
+
+		mu.Lock()
+		requests++
+		mu.Unlock()
+
+		page := 0
+		fmt.Sscanf(sel.Bookmark, "page_%d", &page)
+		if page >= 5 {
+			w.Write([]byte(`{"docs":[],"bookmark":"end"}`))
+			return
+		}
+		fmt.Fprintf(w, `{"docs":[{"year":%d},{"year":%d}],"bookmark":"page_%d"}`, page*2, page*2+1, page+1)
+	}))
+	defer srv.Close()
+
+	db := &CouchDatabase{Name: "movies", cli: &client.CouchClient{BaseAddr: srv.URL, Authentication: client.None, Client: srv.Client()}}
+
+	result, err := db.Select(context.Background(), "{}", nil, map[FindOption]interface{}{OptionPrefetch: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []TestDocument{}
+	if err = result.All(context.Background(), &docs); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(docs) != 10 {
+		t.Fatal("unexpected result:", docs)
+	}
+
+	for i := range docs {
+		if docs[i].Year != i {
+			t.Error("unexpected result:", i, docs[i])
+		}
+	}
+
+	result.Close(context.Background())
+
+	if requests != 6 {
+		t.Error("unexpected result:", requests)
+	}
+
+	//a nil context is accepted in the same way as by other cursors
+	result, err = db.Select(nil, "{}", nil, map[FindOption]interface{}{OptionPrefetch: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.Close(context.Background())
+
+	//the reader does not consume pages, so only the first page and two pages ahead are requested
+	mu.Lock()
+	requests = 0
+	mu.Unlock()
+
+	result, err = db.Select(context.Background(), "{}", nil, map[FindOption]interface{}{OptionPrefetch: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+
+	for i := 0; i < 100 && count() < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if count() != 3 {
+		t.Error("unexpected result:", count())
+	}
+
+	result.Close(context.Background())
+
+	result, err = db.Select(context.Background(), "{}", nil, map[FindOption]interface{}{OptionPrefetch: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Next(context.Background()) {
+		t.Fatal("unexpected result:", result.Err())
+	}
+
+	//closing the cursor stops fetching, it must not hang on a full buffer
+	done := make(chan struct{})
+	go func() {
+		result.Close(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("cursor was not closed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result, err = db.Select(ctx, "{}", nil, map[FindOption]interface{}{OptionPrefetch: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close(context.Background())
+
+	cancel()
+	for result.Next(context.Background()) {
+		result.Decode(&TestDocument{})
+	}
+
+	if !errors.Is(result.Err(), context.Canceled) {
+		t.Error("unexpected result:", result.Err())
+	}
+
+	_, err = db.Select(context.Background(), "{}", nil, map[FindOption]interface{}{OptionPrefetch: 1, OptionStream: true})
+	if err != errStreamPrefetch {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestExecutionStats(t *testing.T) {
+
+	pages := []string{
+		`{"docs":[{"year":1979},{"year":1986}],"bookmark":"page_2","execution_stats":{"total_keys_examined":0,"total_docs_examined":10,"total_quorum_docs_examined":0,"results_returned":2,"execution_time_ms":1.5}}`,
+		`{"docs":[{"year":1992}],"bookmark":"page_3","execution_stats":{"total_keys_examined":0,"total_docs_examined":5,"total_quorum_docs_examined":1,"results_returned":1,"execution_time_ms":0.5}}`,
+		`{"docs":[],"bookmark":"page_4"}`,
+	}
+
+	for _, opt := range []map[FindOption]interface{}{{OptionStat: true}, {OptionStat: true, OptionStream: true}, {OptionStat: true, OptionPrefetch: 1}} {
+
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Write([]byte(pages[calls-1]))
+		}))
+
+		db := &CouchDatabase{Name: "movies", cli: &client.CouchClient{BaseAddr: srv.URL, Authentication: client.None, Client: srv.Client()}}
+
+		result, err := db.Select(context.Background(), "{}", nil, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		docs := []TestDocument{}
+		if err = result.All(context.Background(), &docs); err != nil || len(docs) != 3 {
+			t.Error("unexpected result:", docs, err)
+		}
+		result.Close(context.Background())
+		srv.Close()
+
+		expected := cursor.ExecutionStats{TotalDocsExamined: 15, TotalQuorumDocsExamined: 1, ResultsReturned: 3, ExecutionTimeMs: 2}
+		if meta := result.Meta(); meta.Stats != expected || meta.Documents != 3 || meta.PageStats != (cursor.ExecutionStats{}) {
+			t.Error("unexpected result:", opt, meta)
+		}
+	}
+}
+
+func TestSuggestIndexFields(t *testing.T) {
+
+	query := DataSelector{
+		Selector: json.RawMessage(`{"year":{"$gt":1980},"director":{"name":"Ridley Scott"},"$and":[{"oscars":true}],"$or":[{"title":"Alien"},{"score":9}]}`),
+		Sort:     []SortField{{Field: "year", Direction: SortDesc}},
+	}
+
+	fields, err := suggestIndexFields(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []IndexField{{Name: "year", Direction: SortDesc}, {Name: "director.name"}, {Name: "oscars"}}
+	if len(fields) != len(expected) {
+		t.Fatal("unexpected result:", fields)
+	}
+
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Error("unexpected result:", fields[i])
+		}
+	}
+}
+
+func TestWarningPolicy(t *testing.T) {
+
+	indexes := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, endPointIndex) {
+			data, _ := ioutil.ReadAll(r.Body)
+			indexes = append(indexes, string(data))
+			w.Write([]byte(`{"result":"created","id":"_design/abc","name":"abc"}`))
+			return
+		}
+		w.Write([]byte(`{"docs":[{"year":1979}],"bookmark":"end","warning":"No matching index found, create an index to optimize query time."}`))
+	}))
+	defer srv.Close()
+
+	cli := &client.CouchClient{BaseAddr: srv.URL, Authentication: client.None, Client: srv.Client()}
+	db := &CouchDatabase{Name: "movies", cli: cli}
+
+	if _, err := db.Select(context.Background(), `{"year":1979}`, nil, map[FindOption]interface{}{OptionLimit: 1}); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	warnings := []client.QueryWarning{}
+	hook := func(ctx context.Context, w client.QueryWarning) { warnings = append(warnings, w) }
+
+	cli.SetWarningPolicy(client.WarningPolicy{Mode: client.WarningError, Hook: hook})
+
+	for _, opt := range []map[FindOption]interface{}{{OptionLimit: 1}, {OptionPrefetch: 1}} {
+		result, err := db.Select(context.Background(), `{"year":1979}`, nil, opt)
+		qerr := &QueryWarningError{}
+		if !errors.Is(err, ErrQueryWarning) || !errors.As(err, &qerr) || !qerr.NoIndex() || result.Next(context.Background()) {
+			t.Error("unexpected result:", err)
+		}
+		result.Close(context.Background())
+	}
+
+	result, err := db.Select(context.Background(), `{"year":1979}`, nil, map[FindOption]interface{}{OptionStream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []TestDocument{}
+	if err = result.All(context.Background(), &docs); !errors.Is(err, ErrQueryWarning) {
+		t.Error("unexpected result:", err)
+	}
+
+	db.SetWarningPolicy(client.WarningPolicy{Mode: client.WarningLog, Hook: hook})
+	if _, err = db.Select(context.Background(), `{"year":1979}`, nil, nil); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	if len(warnings) != 4 || warnings[3].Database != "movies" || string(warnings[3].Selector) != `{"year":1979}` {
+		t.Error("unexpected result:", warnings)
+	}
+
+	db.SetWarningPolicy(client.WarningPolicy{Mode: client.WarningCreateIndex, Hook: hook})
+	result, err = db.Select(context.Background(), `{"year":1979,"title":{"$regex":"^A"}}`, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(indexes) != 1 || indexes[0] != `{"index":{"fields":["title","year"]},"type":"json"}` {
+		t.Error("unexpected result:", indexes)
+	}
+
+	if len(warnings) != 5 || warnings[4].Index != "abc" || warnings[4].Err != nil {
+		t.Error("unexpected result:", warnings)
+	}
+
+	//a handler is called once for a cursor
+	result.Next(context.Background())
+	result.Decode(&TestDocument{})
+	result.Next(context.Background())
+	if len(indexes) != 1 {
+		t.Error("unexpected result:", indexes)
+	}
+}
+
+func TestStrictQuery(t *testing.T) {
+
+	_, db, err := GetDatabsase(context.Background(), database, conn.GetClient())
+	if err != nil {
+		t.Error(err)
+	}
+
+	db.SetWarningPolicy(client.WarningPolicy{Mode: client.WarningError})
+
+	_, err = db.Select(context.Background(), selector.Eq("score", 8.1), nil, nil)
+	if !errors.Is(err, ErrQueryWarning) {
+		t.Error("unexpected result:", err)
+	}
+
+	db.SetWarningPolicy(client.WarningPolicy{Mode: client.WarningCreateIndex})
+	if _, err = db.Select(context.Background(), selector.Eq("score", 8.1), nil, nil); err != nil {
+		t.Error("unexpected result:", err)
+	}
+
+	db.SetWarningPolicy(client.WarningPolicy{Mode: client.WarningError})
+	result, err := db.Select(context.Background(), selector.Eq("score", 8.1), nil, nil)
+	if err != nil {
+		t.Fatal("unexpected result:", err)
+	}
+
+	docs := []TestDocument{}
+	if err = result.All(context.Background(), &docs); err != nil || len(docs) != 3 {
+		t.Error("unexpected result:", docs, err)
+	}
+}
+
+func TestSeq(t *testing.T) {
+
+	changes := []Change{}
+	err := json.Unmarshal([]byte(`[{"seq":"3-g1AAAAB","id":"a"},{"seq":12,"id":"b"},{"seq":null,"id":"c"}]`), &changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changes[0].Seq != "3-g1AAAAB" || changes[1].Seq != "12" || changes[2].Seq != "" {
+		t.Error("unexpected result:", changes)
+	}
+
+	data, _ := json.Marshal([]Seq{"12", "3-g1AAAAB", SeqNow})
+	if string(data) != `[12,"3-g1AAAAB","now"]` {
+		t.Error("unexpected result:", string(data))
+	}
+}
+
+func TestChangesFeed(t *testing.T) {
+
+	queries := []url.Values{}
+	bodies := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		data, _ := ioutil.ReadAll(r.Body)
+		queries = append(queries, r.URL.Query())
+		bodies = append(bodies, string(data))
+		since := r.URL.Query().Get("since")
+
+		switch r.URL.Query().Get("feed") {
+		case string(FeedContinuous):
+			if since == "now" {
+				//the connection is dropped without the last_seq
+				w.Write([]byte(`{"seq":"1-a","id":"movie_1","changes":[{"rev":"1-x"}]}` + "\n\n" + `{"seq":"2-a","id":"movie_2","changes":[{"rev":"1-y"}],"deleted":true}` + "\n"))
+				return
+			}
+			w.Write([]byte(`{"seq":"3-a","id":"movie_3","changes":[{"rev":"1-z"}]}` + "\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case string(FeedEventSource):
+			w.Write([]byte("\ndata: {\"seq\":\"1-a\",\"id\":\"movie_1\",\"changes\":[{\"rev\":\"1-x\"}]}\nid: 1-a\n\n"))
+			w.Write([]byte("event: heartbeat\ndata: \n\n"))
+			w.Write([]byte("data: {\"seq\":\"2-a\",\"id\":\"movie_2\",\"changes\":[{\"rev\":\"1-y\"}]}\nid: 2-a\n\n"))
+			w.Write([]byte("data: {\"last_seq\":\"2-a\"}\n\n"))
+		case string(FeedLongpoll):
+			seq := len(since)
+			fmt.Fprintf(w, `{"results":[{"seq":"%d-b","id":"movie_%d","changes":[{"rev":"1-x"}]}],"last_seq":"%d-b","pending":0}`, seq, seq, seq)
+		default:
+			w.Write([]byte(`{"results":[{"seq":"1-a","id":"movie_1","changes":[{"rev":"1-x"}],"doc":{"_id":"movie_1","title":"The Godfather"}},` +
+				`{"seq":"2-a","id":"movie_2","changes":[{"rev":"1-y"}]}],"last_seq":"2-a","pending":0}`))
+		}
+	}))
+	defer srv.Close()
+
+	db := &CouchDatabase{Name: "movies", cli: &client.CouchClient{BaseAddr: srv.URL, Authentication: client.None, Client: srv.Client()}}
+
+	feed, err := db.Changes(context.Background(), ChangesOptions{IncludeDocs: true, DocIDs: []string{"movie_1", "movie_2"}, Style: StyleAllDocs})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for feed.Next() {
+		ids = append(ids, feed.Change().ID)
+		if len(ids) == 1 {
+			doc := TestDocument{}
+			change := feed.Change()
+			if err = change.DecodeDoc(&doc); err != nil || doc.Title != "The Godfather" {
+				t.Error("unexpected result:", doc, err)
+			}
+		}
+	}
+
+	if feed.Err() != nil || len(ids) != 2 || feed.LastSeq() != "2-a" {
+		t.Error("unexpected result:", ids, feed.Err())
+	}
+
+	if queries[0].Get("filter") != "_doc_ids" || queries[0].Get("include_docs") != "true" || queries[0].Get("style") != StyleAllDocs || bodies[0] != `{"doc_ids":["movie_1","movie_2"]}` {
+		t.Error("unexpected result:", queries[0], bodies[0])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	feed, err = db.Changes(ctx, ChangesOptions{Feed: FeedContinuous, Since: SeqNow, Heartbeat: 10 * time.Second, ReconnectDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := []Change{}
+	for feed.Next() {
+		changes = append(changes, feed.Change())
+		if len(changes) == 3 {
+			cancel()
+		}
+	}
+
+	if feed.Err() != nil || len(changes) != 3 || !changes[1].Deleted || changes[2].ID != "movie_3" {
+		t.Error("unexpected result:", changes, feed.Err())
+	}
+
+	last := queries[len(queries)-1]
+	if last.Get("since") != "2-a" || last.Get("heartbeat") != "10000" {
+		t.Error("unexpected result:", last)
+	}
+
+	//a heartbeat between changes neither ends the feed nor causes a reconnection
+	requests := len(queries)
+	feed, err = db.Changes(context.Background(), ChangesOptions{Feed: FeedEventSource, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes = []Change{}
+	for feed.Next() {
+		changes = append(changes, feed.Change())
+	}
+
+	if feed.Err() != nil || len(changes) != 2 || changes[0].Seq != "1-a" || changes[1].Seq != "2-a" || len(queries)-requests != 1 {
+		t.Error("unexpected result:", changes, feed.Err(), len(queries)-requests)
+	}
+
+	feed, err = db.Changes(context.Background(), ChangesOptions{Feed: FeedLongpoll, Since: "0", Limit: 3, Selector: selector.Eq("year", 1980)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids = []string{}
+	for feed.Next() {
+		ids = append(ids, feed.Change().ID)
+	}
+
+	if feed.Err() != nil || len(ids) != 3 || ids[2] != "movie_3" || feed.LastSeq() != "3-b" {
+		t.Error("unexpected result:", ids, feed.Err())
+	}
+
+	last = queries[len(queries)-1]
+	if last.Get("filter") != "_selector" || last.Get("limit") != "1" || bodies[len(bodies)-1] != `{"selector":{"year":{"$eq":1980}}}` {
+		t.Error("unexpected result:", last, bodies[len(bodies)-1])
+	}
+
+	//a longpoll feed gets a heartbeat by default, so the server sends response headers at once
+	if last.Get("heartbeat") != "10000" || queries[0].Get("heartbeat") != "" {
+		t.Error("unexpected result:", last, queries[0])
+	}
+
+	feed, err = db.Changes(nil, ChangesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.Close()
+
+	if _, err = db.Changes(context.Background(), ChangesOptions{Design: true, DocIDs: []string{"a"}}); err != errMultipleFilters {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestChanges(t *testing.T) {
+
+	_, db, err := GetDatabsase(context.Background(), database, conn.GetClient())
+	if err != nil {
+		t.Error(err)
+	}
+
+	feed, err := db.Changes(context.Background(), ChangesOptions{Feed: FeedNormal, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for feed.Next() {
+		count++
+	}
+
+	if feed.Err() != nil || count != 2 || feed.LastSeq() == "" {
+		t.Error("unexpected result:", count, feed.Err())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	feed, err = db.Changes(ctx, ChangesOptions{Feed: FeedContinuous, Since: SeqNow, Heartbeat: time.Second, IncludeDocs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Close()
+
+	doc := TestDocument{ID: "changes_document_01", Title: "Alien", Year: 1979}
+	if _, err = db.Insert(context.Background(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if !feed.Next() || feed.Change().ID != "changes_document_01" {
+		t.Error("unexpected result:", feed.Change(), feed.Err())
+	}
+}
+
 func TestDropDatabase(t *testing.T) {
 
 	_, err := DropDatabase(context.Background(), database, conn.GetClient())
@@ -15,14 +15,33 @@ const (
 	endPointPurge    = "_purge"
 	endPointSecurity = "_security"
 
+	designPrefix = "_design/"
+	localPrefix  = "_local/"
+
 	OptionStat     FindOption = "stat"
 	OptionBookmark FindOption = "bookmark"
 	OptionLimit    FindOption = "limit"
-	OptionIndex    FindOption = "index"
+	//OptionIndex - a name of a design document or a slice of a design document and a name of an index
+	OptionIndex FindOption = "index"
+	//OptionSort - a slice of SortField, a slice of field names sorted ascending or a slice of {field: asc|desc} maps
+	OptionSort      FindOption = "sort"
+	OptionSkip      FindOption = "skip"
+	OptionR         FindOption = "r"
+	OptionConflicts FindOption = "conflicts"
+	OptionUpdate    FindOption = "update"
+	OptionStable    FindOption = "stable"
+	//OptionStream - documents are decoded while they are read from a response instead of buffering a whole page
+	OptionStream FindOption = "stream"
+	/*OptionPrefetch - a number of pages fetched in the background ahead of the page being iterated. Pages are fetched
+	with the context passed to Select and the cursor must be closed to stop fetching.
+	*/
+	OptionPrefetch FindOption = "prefetch"
 )
 
 var (
 	errNilSelector          = errors.New("nil selector specified")
+	errInvalidSelector      = errors.New("selector is not a valid JSON object")
+	errSelectorKind         = errors.New("invalid kind of selector, not a string, []byte or json.Marshaler")
 	errInvalidDocKind       = errors.New("invalid kind of document, not a ptr to slice, or not a ptr to struct")
 	errEmptyDocumentID      = errors.New("document id cannot be empty")
 	errRequiredDocumentID   = errors.New("document id required")
@@ -30,6 +49,13 @@ var (
 	errIDandRevRequired     = errors.New("id and rev fields are required")
 	errRevListRequired      = errors.New("revision list cannot be empty")
 	errSecurityDataEmpty    = errors.New("empty security data")
+	errEmptyAttachmentName  = errors.New("attachment name cannot be empty")
+	errNilAttachmentBody    = errors.New("attachment body cannot be nil")
+	errInvalidRange         = errors.New("invalid range")
+	errInvalidFindOption    = errors.New("invalid value of find option")
+	errInvalidSort          = errors.New("invalid sort, expected a field name and asc or desc")
+	errMixedSortDirection   = errors.New("all sort fields must have the same direction")
+	errStreamPrefetch       = errors.New("stream and prefetch options cannot be combined")
 )
 
 type arrrayDocument struct {
@@ -42,18 +68,44 @@ type CopyDocument struct {
 	Rev string
 }
 
+//SortField - A field used to sort results of a query, Direction is either asc or desc
+type SortField struct {
+	Field     string
+	Direction string
+}
+
+//MarshalJSON - Marshals a field to {"field":"asc"}
+func (f SortField) MarshalJSON() ([]byte, error) {
+
+	direction := f.Direction
+	if direction == "" {
+		direction = SortAsc
+	}
+
+	return json.Marshal(map[string]string{f.Field: direction})
+}
+
 //DataSelector - Contains strucutrued used in _find request
 type DataSelector struct {
-	Selector json.RawMessage `json:"selector"`
-	Fields   []string        `json:"fields,omitempty"`
-	Limit    int             `json:"limit,omitempty"`
-	Bookmark string          `json:"bookmark,omitempty"`
-	Index    string          `json:"use_index,omitempty"`
-	Stats    bool            `json:"execution_stats,omitempty"`
+	Selector  json.RawMessage `json:"selector"`
+	Fields    []string        `json:"fields,omitempty"`
+	Sort      []SortField     `json:"sort,omitempty"`
+	Limit     int             `json:"limit,omitempty"`
+	Skip      int             `json:"skip,omitempty"`
+	Bookmark  string          `json:"bookmark,omitempty"`
+	Index     []string        `json:"use_index,omitempty"`
+	R         int             `json:"r,omitempty"`
+	Conflicts bool            `json:"conflicts,omitempty"`
+	Update    *bool           `json:"update,omitempty"`
+	Stable    bool            `json:"stable,omitempty"`
+	Stats     bool            `json:"execution_stats,omitempty"`
+	Stream    bool            `json:"-"`
+	Prefetch  int             `json:"-"`
 }
 
 //CouchDatabase - Represents a CouchDB database
 type CouchDatabase struct {
-	Name string
-	cli  *client.CouchClient
+	Name    string
+	cli     *client.CouchClient
+	warning *client.WarningPolicy
 }
@@ -0,0 +1,456 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/przebro/couchdb/request"
+	"github.com/przebro/couchdb/response"
+)
+
+const (
+	languageJavaScript = "javascript"
+
+	designViewsDir      = "views"
+	designFiltersDir    = "filters"
+	designUpdatesDir    = "updates"
+	designMapFile       = "map.js"
+	designReduceFile    = "reduce.js"
+	designValidateFile  = "validate_doc_update.js"
+	designOptionsFile   = "options.json"
+	designScriptPostfix = ".js"
+)
+
+//designFields - members of a design document that are defined by the DesignDocument
+var designFields = []string{"language", "views", "filters", "updates", "validate_doc_update", "options", "autoupdate"}
+
+var (
+	errEmptyDesignName = errors.New("design document name cannot be empty")
+	errNilDesign       = errors.New("nil design document")
+	errMissingMapFunc  = errors.New("view requires a map function")
+)
+
+//ViewDefinition - Map and an optional reduce function of a view, reduce may also be a built-in function like _count or _sum
+type ViewDefinition struct {
+	Map    string `json:"map"`
+	Reduce string `json:"reduce,omitempty"`
+}
+
+//DesignOptions - Options of a design document
+type DesignOptions struct {
+	Partitioned   *bool `json:"partitioned,omitempty"`
+	LocalSeq      bool  `json:"local_seq,omitempty"`
+	IncludeDesign bool  `json:"include_design,omitempty"`
+}
+
+//DesignDocument - Represents a design document, the ID is a name of a document with or without the _design/ prefix
+type DesignDocument struct {
+	ID                string                    `json:"_id"`
+	Rev               string                    `json:"_rev,omitempty"`
+	Language          string                    `json:"language,omitempty"`
+	Views             map[string]ViewDefinition `json:"views,omitempty"`
+	Filters           map[string]string         `json:"filters,omitempty"`
+	Updates           map[string]string         `json:"updates,omitempty"`
+	ValidateDocUpdate string                    `json:"validate_doc_update,omitempty"`
+	Options           *DesignOptions            `json:"options,omitempty"`
+	AutoUpdate        *bool                     `json:"autoupdate,omitempty"`
+}
+
+//Name - Returns a name of the design document without the _design/ prefix
+func (d *DesignDocument) Name() string {
+	return strings.TrimPrefix(d.ID, designPrefix)
+}
+
+//validate - checks the ddoc before it is sent to the server
+func (d *DesignDocument) validate() error {
+
+	if d.Name() == "" {
+		return errEmptyDesignName
+	}
+
+	for _, v := range d.Views {
+		if v.Map == "" {
+			return errMissingMapFunc
+		}
+	}
+
+	return nil
+}
+
+/*mergeDesign - merges definitions of the ddoc into a copy of the document stored on the server. Members that are not
+defined by the DesignDocument e.g. shows, lists or options of a view are kept as they are.
+*/
+func mergeDesign(current map[string]interface{}, ddoc *DesignDocument) (map[string]interface{}, error) {
+
+	data, err := json.Marshal(ddoc)
+	if err != nil {
+		return nil, err
+	}
+
+	managed := map[string]interface{}{}
+	if err = json.Unmarshal(data, &managed); err != nil {
+		return nil, err
+	}
+
+	merged := map[string]interface{}{}
+	for k, v := range current {
+		merged[k] = v
+	}
+
+	for _, k := range designFields {
+		v, ok := managed[k]
+		switch {
+		case ok && k == "views":
+			merged[k] = mergeViews(current[k], v.(map[string]interface{}))
+		case ok:
+			merged[k] = v
+		case k != "language":
+			//an empty language means the default one, so the stored value is not changed
+			delete(merged, k)
+		}
+	}
+
+	merged["_id"] = designID(ddoc.ID)
+
+	return merged, nil
+}
+
+//mergeViews - sets functions of views and keeps other members of views that already exist on the server
+func mergeViews(current interface{}, views map[string]interface{}) map[string]interface{} {
+
+	stored, _ := current.(map[string]interface{})
+	merged := map[string]interface{}{}
+
+	for name, v := range views {
+		view := map[string]interface{}{}
+		if sv, ok := stored[name].(map[string]interface{}); ok {
+			for k, x := range sv {
+				view[k] = x
+			}
+		}
+
+		delete(view, "reduce")
+		for k, x := range v.(map[string]interface{}) {
+			view[k] = x
+		}
+
+		merged[name] = view
+	}
+
+	return merged
+}
+
+//GetDesign - Gets a design document with given name
+func (db *CouchDatabase) GetDesign(ctx context.Context, name string) (*DesignDocument, error) {
+
+	ddoc := &DesignDocument{}
+	if err := db.getDesign(ctx, name, ddoc); err != nil {
+		return nil, err
+	}
+
+	return ddoc, nil
+}
+
+//getDesign - gets a design document with given name and decodes it into v
+func (db *CouchDatabase) getDesign(ctx context.Context, name string, v interface{}) error {
+
+	if strings.TrimPrefix(name, designPrefix) == "" {
+		return errEmptyDesignName
+	}
+
+	endpoint := fmt.Sprintf("%s/%s", db.Name, documentPath(designID(name)))
+	rqb := request.NewRequestBuilder()
+
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodGet).Build(db.cli)
+	if err != nil {
+		return err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return err
+	}
+
+	result := response.NewResult(rs.CouchStatus, rs.Rdr)
+	defer result.Close()
+
+	if err = response.CheckStatus(&rs, endpoint); err != nil {
+		return err
+	}
+
+	return result.Decode(v)
+}
+
+/*PutDesign - Creates a design document or updates it if the revision is set. If successful then the revision
+of the ddoc is updated to the new one, so the document can be modified and put again. The stored document is replaced,
+so members that are not defined by the DesignDocument are removed, use SyncDesign to keep them.
+*/
+func (db *CouchDatabase) PutDesign(ctx context.Context, ddoc *DesignDocument) (*response.CouchResult, error) {
+
+	if ddoc == nil {
+		return nil, errNilDesign
+	}
+
+	if err := ddoc.validate(); err != nil {
+		return nil, err
+	}
+
+	ddoc.ID = designID(ddoc.ID)
+
+	data, err := json.Marshal(ddoc)
+	if err != nil {
+		return nil, err
+	}
+
+	result, rev, err := db.putDesign(ctx, ddoc.ID, data)
+	if err == nil {
+		ddoc.Rev = rev
+	}
+
+	return result, err
+}
+
+//putDesign - puts the data of a design document and returns the new revision
+func (db *CouchDatabase) putDesign(ctx context.Context, id string, data []byte) (*response.CouchResult, string, error) {
+
+	endpoint := fmt.Sprintf("%s/%s", db.Name, documentPath(id))
+	rqb := request.NewRequestBuilder()
+
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodPut).WithBody(data).Build(db.cli)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = response.CheckStatus(&rs, endpoint); err != nil {
+		return response.NewResult(rs.CouchStatus, rs.Rdr), "", err
+	}
+
+	//the body is read to get the revision, the result gets a copy of it
+	body, err := ioutil.ReadAll(rs.Rdr)
+	rs.Rdr.Close()
+	if err != nil {
+		return nil, "", err
+	}
+
+	status := struct {
+		Rev string `json:"rev"`
+	}{}
+	if err = json.Unmarshal(body, &status); err != nil {
+		return nil, "", err
+	}
+
+	return response.NewResult(rs.CouchStatus, ioutil.NopCloser(bytes.NewReader(body))), status.Rev, nil
+}
+
+//DeleteDesign - Deletes a design document with given name and revision
+func (db *CouchDatabase) DeleteDesign(ctx context.Context, name, rev string) (*response.CouchResult, error) {
+
+	if strings.TrimPrefix(name, designPrefix) == "" {
+		return nil, errEmptyDesignName
+	}
+
+	return db.Delete(ctx, documentPath(designID(name)), rev)
+}
+
+/*SyncDesign - Compares the ddoc with a copy stored on the server and updates it only if they differ. Returns true
+if the document was created or updated. The revision of the ddoc is set to the current revision on the server.
+Members of the stored document that are not defined by the DesignDocument e.g. shows, lists or options of views are kept.
+*/
+func (db *CouchDatabase) SyncDesign(ctx context.Context, ddoc *DesignDocument) (bool, error) {
+
+	if ddoc == nil {
+		return false, errNilDesign
+	}
+
+	if err := ddoc.validate(); err != nil {
+		return false, err
+	}
+
+	current := map[string]interface{}{}
+	err := db.getDesign(ctx, ddoc.ID, &current)
+	if errors.Is(err, response.ErrNotFound) {
+		ddoc.Rev = ""
+		result, err := db.PutDesign(ctx, ddoc)
+		if err != nil {
+			return false, err
+		}
+		result.Close()
+
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	merged, err := mergeDesign(current, ddoc)
+	if err != nil {
+		return false, err
+	}
+
+	ddoc.ID = designID(ddoc.ID)
+	ddoc.Rev, _ = current["_rev"].(string)
+
+	//maps are marshalled with sorted keys, so equal documents give the same data
+	stored, err := json.Marshal(current)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(stored, data) {
+		return false, nil
+	}
+
+	result, rev, err := db.putDesign(ctx, ddoc.ID, data)
+	if err != nil {
+		return false, err
+	}
+	result.Close()
+	ddoc.Rev = rev
+
+	return true, nil
+}
+
+/*LoadDesign - Loads a design document from a directory of a file system e.g. an embed.FS.
+The directory name is used as the name of the document and the layout of the directory is:
+
+	views/<view>/map.js
+	views/<view>/reduce.js
+	filters/<filter>.js
+	updates/<update>.js
+	validate_doc_update.js
+	options.json
+
+All files are optional, except that a view directory must contain map.js.
+*/
+func LoadDesign(fsys fs.FS, dir string) (*DesignDocument, error) {
+
+	name := path.Base(dir)
+	if name == "." || name == "/" {
+		return nil, errEmptyDesignName
+	}
+
+	ddoc := &DesignDocument{ID: designID(name), Language: languageJavaScript}
+
+	views, err := fs.ReadDir(fsys, path.Join(dir, designViewsDir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, entry := range views {
+		if !entry.IsDir() {
+			continue
+		}
+
+		vdir := path.Join(dir, designViewsDir, entry.Name())
+		mapFn, err := readScript(fsys, path.Join(vdir, designMapFile))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", vdir, err)
+		}
+		if mapFn == "" {
+			return nil, fmt.Errorf("%s: %w", vdir, errMissingMapFunc)
+		}
+
+		reduceFn, err := readScript(fsys, path.Join(vdir, designReduceFile))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", vdir, err)
+		}
+
+		if ddoc.Views == nil {
+			ddoc.Views = map[string]ViewDefinition{}
+		}
+		ddoc.Views[entry.Name()] = ViewDefinition{Map: mapFn, Reduce: reduceFn}
+	}
+
+	if ddoc.Filters, err = readScripts(fsys, path.Join(dir, designFiltersDir)); err != nil {
+		return nil, err
+	}
+
+	if ddoc.Updates, err = readScripts(fsys, path.Join(dir, designUpdatesDir)); err != nil {
+		return nil, err
+	}
+
+	if ddoc.ValidateDocUpdate, err = readScript(fsys, path.Join(dir, designValidateFile)); err != nil {
+		return nil, err
+	}
+
+	data, err := fs.ReadFile(fsys, path.Join(dir, designOptionsFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if len(data) > 0 {
+		ddoc.Options = &DesignOptions{}
+		if err = json.Unmarshal(data, ddoc.Options); err != nil {
+			return nil, fmt.Errorf("%s: %w", designOptionsFile, err)
+		}
+	}
+
+	return ddoc, nil
+}
+
+//readScript - reads a function from a file, returns an empty string if the file does not exist
+func readScript(fsys fs.FS, name string) (string, error) {
+
+	data, err := fs.ReadFile(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+
+	return strings.TrimSpace(string(data)), err
+}
+
+//readScripts - reads all .js files from a directory, a name of a file without the extension is a key
+func readScripts(fsys fs.FS, dir string) (map[string]string, error) {
+
+	entries, err := fs.ReadDir(fsys, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var scripts map[string]string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), designScriptPostfix) {
+			continue
+		}
+
+		script, err := readScript(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if scripts == nil {
+			scripts = map[string]string{}
+		}
+		scripts[strings.TrimSuffix(entry.Name(), designScriptPostfix)] = script
+	}
+
+	return scripts, nil
+}
+
+//designID - adds the _design/ prefix to a name if it is missing
+func designID(name string) string {
+	if strings.HasPrefix(name, designPrefix) {
+		return name
+	}
+	return designPrefix + name
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/przebro/couchdb/request"
+	"github.com/przebro/couchdb/response"
+)
+
+const (
+	endPointExplain = "_explain"
+	allFields       = "all_fields"
+)
+
+//ExplainRange - Range of keys scanned in the chosen index
+type ExplainRange struct {
+	StartKey  json.RawMessage `json:"start_key"`
+	EndKey    json.RawMessage `json:"end_key"`
+	Direction string          `json:"direction"`
+}
+
+//ExplainOptions - Final options of a query, after defaults have been applied by the server
+type ExplainOptions struct {
+	UseIndex       []string        `json:"use_index"`
+	Bookmark       string          `json:"bookmark"`
+	Limit          int             `json:"limit"`
+	Skip           int             `json:"skip"`
+	Sort           json.RawMessage `json:"sort"`
+	Fields         json.RawMessage `json:"fields"`
+	Partition      string          `json:"partition"`
+	R              json.RawMessage `json:"r"`
+	Conflicts      bool            `json:"conflicts"`
+	Stale          bool            `json:"stale"`
+	Update         bool            `json:"update"`
+	Stable         bool            `json:"stable"`
+	ExecutionStats bool            `json:"execution_stats"`
+}
+
+//IndexCandidate - An index considered by the query planner, Reasons tell why it was not chosen
+type IndexCandidate struct {
+	Index    IndexDefinition
+	Usable   bool
+	Reasons  []string
+	Ranking  int
+	Covering bool
+}
+
+//ExplainPlan - Describes how the server executes a query
+type ExplainPlan struct {
+	DB         string
+	Index      IndexDefinition
+	Candidates []IndexCandidate
+	Selector   json.RawMessage
+	Range      ExplainRange
+	Options    ExplainOptions
+	Limit      int
+	Skip       int
+	//Fields - fields returned by the query, nil means all fields
+	Fields []string
+}
+
+//FullScan - Returns true if the query is executed against the _all_docs index i.e. scans the whole database
+func (p *ExplainPlan) FullScan() bool {
+	return p.Index.Type == IndexTypeSpecial
+}
+
+type explainCandidate struct {
+	Index    indexEntry `json:"index"`
+	Analysis struct {
+		Usable  bool `json:"usable"`
+		Reasons []struct {
+			Name string `json:"name"`
+		} `json:"reasons"`
+		Ranking  int  `json:"ranking"`
+		Covering bool `json:"covering"`
+	} `json:"analysis"`
+}
+
+type explainResult struct {
+	DB         string             `json:"dbname"`
+	Index      indexEntry         `json:"index"`
+	Candidates []explainCandidate `json:"index_candidates"`
+	Selector   json.RawMessage    `json:"selector"`
+	Options    ExplainOptions     `json:"opts"`
+	Limit      int                `json:"limit"`
+	Skip       int                `json:"skip"`
+	Fields     json.RawMessage    `json:"fields"`
+	Range      ExplainRange       `json:"mrargs"`
+}
+
+//Explain - Returns a plan of a query, takes the same arguments as Select
+func (db *CouchDatabase) Explain(ctx context.Context, sel interface{}, fld []string, opt map[FindOption]interface{}) (*ExplainPlan, error) {
+
+	query, err := db.newQuery(sel, fld, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/%s", db.Name, endPointExplain)
+	rqb := request.NewRequestBuilder()
+
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodPost).WithBody(body).WithIdempotent(true).Build(db.cli)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := response.NewResult(rs.CouchStatus, rs.Rdr)
+	defer result.Close()
+
+	if err = response.CheckStatus(&rs, endpoint); err != nil {
+		return nil, err
+	}
+
+	er := explainResult{}
+	if err = result.Decode(&er); err != nil {
+		return nil, err
+	}
+
+	return newExplainPlan(er)
+}
+
+func newExplainPlan(er explainResult) (*ExplainPlan, error) {
+
+	plan := &ExplainPlan{
+		DB:       er.DB,
+		Index:    er.Index.definition(),
+		Selector: er.Selector,
+		Range:    er.Range,
+		Options:  er.Options,
+		Limit:    er.Limit,
+		Skip:     er.Skip,
+	}
+
+	for _, c := range er.Candidates {
+		candidate := IndexCandidate{
+			Index:    c.Index.definition(),
+			Usable:   c.Analysis.Usable,
+			Ranking:  c.Analysis.Ranking,
+			Covering: c.Analysis.Covering,
+		}
+		for _, r := range c.Analysis.Reasons {
+			candidate.Reasons = append(candidate.Reasons, r.Name)
+		}
+		plan.Candidates = append(plan.Candidates, candidate)
+	}
+
+	//fields are either a list of names or the all_fields string
+	if len(er.Fields) > 0 && string(er.Fields) != fmt.Sprintf(`"%s"`, allFields) {
+		if err := json.Unmarshal(er.Fields, &plan.Fields); err != nil {
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
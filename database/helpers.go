@@ -1,6 +1,9 @@
 package database
 
 import (
+	"bytes"
+	"encoding/json"
+	"net/url"
 	"reflect"
 	"strings"
 )
@@ -73,3 +76,51 @@ func requiredFields(doc interface{}) (string, string, error) {
 
 	return id, rev, nil
 }
+
+//documentPath - escapes a document id so it can be used in a path, prefixes of design and local documents are preserved
+func documentPath(id string) string {
+
+	for _, prefix := range []string{designPrefix, localPrefix} {
+		if strings.HasPrefix(id, prefix) {
+			return prefix + url.PathEscape(strings.TrimPrefix(id, prefix))
+		}
+	}
+
+	return url.PathEscape(id)
+}
+
+//selectorData - converts a selector to JSON, the selector must be a JSON object
+func selectorData(sel interface{}) (json.RawMessage, error) {
+
+	var data []byte
+
+	switch val := sel.(type) {
+	case nil:
+		return nil, errNilSelector
+	case string:
+		data = []byte(val)
+	case []byte:
+		data = val
+	case json.RawMessage:
+		data = val
+	case json.Marshaler:
+		d, err := val.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		data = d
+	default:
+		return nil, errSelectorKind
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errNilSelector
+	}
+
+	if data[0] != '{' || !json.Valid(data) {
+		return nil, errInvalidSelector
+	}
+
+	return data, nil
+}
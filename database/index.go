@@ -1,11 +1,317 @@
 package database
 
-//Index - Creates  a new index on a database
-func (db *CouchDatabase) Index(doc interface{}) {
-	//posr db/_index
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/przebro/couchdb/request"
+	"github.com/przebro/couchdb/response"
+)
+
+const endPointIndex = "_index"
+
+//IndexType - Type of a Mango index
+type IndexType string
+
+//Types of indexes
+const (
+	IndexTypeJSON    IndexType = "json"
+	IndexTypeText    IndexType = "text"
+	IndexTypeSpecial IndexType = "special"
+)
+
+//Sort directions of fields of a json index
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+//Results of creating an index
+const (
+	IndexCreated = "created"
+	IndexExists  = "exists"
+)
+
+var (
+	errEmptyIndexFields  = errors.New("index requires at least one field")
+	errEmptyIndexField   = errors.New("index field name cannot be empty")
+	errInvalidIndexType  = errors.New("invalid index type")
+	errInvalidDirection  = errors.New("invalid sort direction, expected asc or desc")
+	errIndexNameRequired = errors.New("ddoc and name of the index are required")
+)
+
+/*IndexField - A field of an index. Direction applies only to json indexes and Type only to text indexes,
+where it is one of: string, number, boolean.
+*/
+type IndexField struct {
+	Name      string
+	Direction string
+	Type      string
+}
+
+//MarshalJSON - Marshals a field to a form used by a json index: "name" or {"name":"desc"}
+func (f IndexField) MarshalJSON() ([]byte, error) {
+
+	if f.Type != "" {
+		return json.Marshal(map[string]string{"name": f.Name, "type": f.Type})
+	}
+
+	if f.Direction == "" {
+		return json.Marshal(f.Name)
+	}
+
+	return json.Marshal(map[string]string{f.Name: f.Direction})
+}
+
+//UnmarshalJSON - Unmarshals a field of an index definition returned by the server
+func (f *IndexField) UnmarshalJSON(data []byte) error {
+
+	name := ""
+	if err := json.Unmarshal(data, &name); err == nil {
+		*f = IndexField{Name: name, Direction: SortAsc}
+		return nil
+	}
+
+	fields := map[string]string{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	if n, ok := fields["name"]; ok && len(fields) == 2 {
+		*f = IndexField{Name: n, Type: fields["type"]}
+		return nil
+	}
+
+	for k, v := range fields {
+		if v == SortAsc || v == SortDesc {
+			*f = IndexField{Name: k, Direction: v}
+		} else {
+			*f = IndexField{Name: k, Type: v}
+		}
+	}
+
+	return nil
+}
+
+//IndexDefinition - Describes a Mango index. Without DDoc and Name the server generates them from a hash of the definition
+type IndexDefinition struct {
+	DDoc                  string
+	Name                  string
+	Type                  IndexType
+	Fields                []IndexField
+	PartialFilterSelector json.RawMessage
+	Partitioned           *bool
+}
+
+//validate - checks the definition before it is sent
+func (d *IndexDefinition) validate() error {
+
+	if d.Type != "" && d.Type != IndexTypeJSON && d.Type != IndexTypeText {
+		return errInvalidIndexType
+	}
+
+	if len(d.Fields) == 0 && d.Type != IndexTypeText {
+		return errEmptyIndexFields
+	}
+
+	for _, f := range d.Fields {
+		if f.Name == "" {
+			return errEmptyIndexField
+		}
+		if f.Direction != "" && f.Direction != SortAsc && f.Direction != SortDesc {
+			return errInvalidDirection
+		}
+	}
+
+	return nil
+}
+
+type indexBody struct {
+	Fields                []IndexField    `json:"fields,omitempty"`
+	PartialFilterSelector json.RawMessage `json:"partial_filter_selector,omitempty"`
+}
+
+type indexRequest struct {
+	Index       indexBody `json:"index"`
+	DDoc        string    `json:"ddoc,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Type        IndexType `json:"type,omitempty"`
+	Partitioned *bool     `json:"partitioned,omitempty"`
+}
+
+type indexEntry struct {
+	DDoc        *string   `json:"ddoc"`
+	Name        string    `json:"name"`
+	Type        IndexType `json:"type"`
+	Partitioned *bool     `json:"partitioned,omitempty"`
+	Def         indexBody `json:"def"`
+}
+
+//definition - converts an index returned by the server, ddoc of the special index is null
+func (e indexEntry) definition() IndexDefinition {
+
+	def := IndexDefinition{
+		Name:                  e.Name,
+		Type:                  e.Type,
+		Fields:                e.Def.Fields,
+		PartialFilterSelector: e.Def.PartialFilterSelector,
+		Partitioned:           e.Partitioned,
+	}
+
+	if e.DDoc != nil {
+		def.DDoc = *e.DDoc
+	}
+
+	return def
 }
 
-//GetIndex - gets a list of indexes on current database
-func (db *CouchDatabase) GetIndex(doc interface{}) {
-	//get db/_index
+type indexList struct {
+	TotalRows int          `json:"total_rows"`
+	Indexes   []indexEntry `json:"indexes"`
+}
+
+//IndexResult - Result of creating an index, Result is either IndexCreated or IndexExists
+type IndexResult struct {
+	Result string `json:"result"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+}
+
+//Index - Creates a new index on a database, if an identical index already exists then the Result is IndexExists
+func (db *CouchDatabase) Index(ctx context.Context, def IndexDefinition) (*IndexResult, error) {
+
+	if err := def.validate(); err != nil {
+		return nil, err
+	}
+
+	body := indexRequest{
+		Index:       indexBody{Fields: def.Fields, PartialFilterSelector: def.PartialFilterSelector},
+		DDoc:        def.DDoc,
+		Name:        def.Name,
+		Type:        def.Type,
+		Partitioned: def.Partitioned,
+	}
+
+	data, err := json.Marshal(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/%s", db.Name, endPointIndex)
+	rqb := request.NewRequestBuilder()
+
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodPost).WithBody(data).Build(db.cli)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := response.NewResult(rs.CouchStatus, rs.Rdr)
+	defer result.Close()
+
+	if err = response.CheckStatus(&rs, endpoint); err != nil {
+		return nil, err
+	}
+
+	ir := &IndexResult{}
+	if err = result.Decode(ir); err != nil {
+		return nil, err
+	}
+
+	return ir, nil
+}
+
+//GetIndex - gets a list of indexes on current database, including the special _all_docs index
+func (db *CouchDatabase) GetIndex(ctx context.Context) ([]IndexDefinition, error) {
+
+	endpoint := fmt.Sprintf("%s/%s", db.Name, endPointIndex)
+	rqb := request.NewRequestBuilder()
+
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodGet).Build(db.cli)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := response.NewResult(rs.CouchStatus, rs.Rdr)
+	defer result.Close()
+
+	if err = response.CheckStatus(&rs, endpoint); err != nil {
+		return nil, err
+	}
+
+	list := indexList{}
+	if err = result.Decode(&list); err != nil {
+		return nil, err
+	}
+
+	indexes := make([]IndexDefinition, 0, len(list.Indexes))
+	for _, e := range list.Indexes {
+		indexes = append(indexes, e.definition())
+	}
+
+	return indexes, nil
+}
+
+//DeleteIndex - Deletes an index, ddoc can be given with or without the _design/ prefix and type defaults to json
+func (db *CouchDatabase) DeleteIndex(ctx context.Context, ddoc string, tp IndexType, name string) (*response.CouchResult, error) {
+
+	if ddoc == "" || name == "" {
+		return nil, errIndexNameRequired
+	}
+
+	if tp == "" {
+		tp = IndexTypeJSON
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/%s/%s/%s", db.Name, endPointIndex, documentPath(designID(ddoc)), tp, documentPath(name))
+	rqb := request.NewRequestBuilder()
+
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodDelete).Build(db.cli)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endpoint)
+
+	return response.NewResult(rs.CouchStatus, rs.Rdr), err
+}
+
+/*EnsureIndexes - Creates the declared indexes, indexes that already exist are left untouched so it is safe
+to call it at every startup. All definitions are validated before any index is created.
+*/
+func (db *CouchDatabase) EnsureIndexes(ctx context.Context, defs ...IndexDefinition) ([]IndexResult, error) {
+
+	for i := range defs {
+		if err := defs[i].validate(); err != nil {
+			return nil, fmt.Errorf("index %d: %w", i, err)
+		}
+	}
+
+	results := make([]IndexResult, 0, len(defs))
+	for _, def := range defs {
+
+		result, err := db.Index(ctx, def)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, *result)
+	}
+
+	return results, nil
 }
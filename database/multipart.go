@@ -0,0 +1,335 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/przebro/couchdb/request"
+	"github.com/przebro/couchdb/response"
+)
+
+const (
+	mimeJSON           = "application/json"
+	mimeMultipart      = "multipart/related"
+	attachmentsField   = "_attachments"
+	defaultContentType = "application/octet-stream"
+)
+
+//Attachment - An attachment sent along with a document, the length is required and the body must provide exactly length bytes
+type Attachment struct {
+	Name        string
+	ContentType string
+	Length      int64
+	Body        io.Reader
+}
+
+type attachmentStub struct {
+	Follows     bool   `json:"follows"`
+	ContentType string `json:"content_type"`
+	Length      int64  `json:"length"`
+}
+
+/*InsertWithAttachments - Creates or updates a document together with attachments in a single multipart/related request,
+so the document gets only one new revision. The document must contain _id and, if it already exists, _rev. Attachments of
+the existing document that are not listed in its _attachments field are removed, as with a regular update.
+*/
+func (db *CouchDatabase) InsertWithAttachments(ctx context.Context, doc interface{}, attachments []Attachment) (*response.CouchResult, error) {
+
+	id, _, err := requiredFields(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if id == "" {
+		return nil, errRequiredDocumentID
+	}
+
+	atts := make([]Attachment, len(attachments))
+	copy(atts, attachments)
+
+	//attachments must be sent in the same order as they appear in the _attachments object, which is marshaled in key order
+	sort.Slice(atts, func(i, j int) bool { return atts[i].Name < atts[j].Name })
+
+	for i := range atts {
+		if atts[i].Name == "" {
+			return nil, errEmptyAttachmentName
+		}
+		if atts[i].Body == nil || atts[i].Length < 0 {
+			return nil, errNilAttachmentBody
+		}
+		if atts[i].ContentType == "" {
+			atts[i].ContentType = defaultContentType
+		}
+	}
+
+	data, err := multipartDocument(doc, atts)
+	if err != nil {
+		return nil, err
+	}
+
+	boundary := multipart.NewWriter(nil).Boundary()
+
+	length, err := multipartLength(boundary, data, atts)
+	if err != nil {
+		return nil, err
+	}
+
+	rdr, wr := io.Pipe()
+
+	go func() {
+		wr.CloseWithError(writeMultipart(wr, boundary, data, atts))
+	}()
+	defer rdr.Close()
+
+	endpoint := fmt.Sprintf("%s/%s", db.Name, documentPath(id))
+	rqb := request.NewRequestBuilder()
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodPut).
+		WithHeaders(map[string]string{"Content-Type": mime.FormatMediaType(mimeMultipart, map[string]string{"boundary": boundary})}).
+		WithBodyReader(rdr).
+		WithContentLength(length).
+		Build(db.cli)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endpoint)
+
+	return response.NewResult(rs.CouchStatus, rs.Rdr), err
+}
+
+//multipartDocument - marshals the document and adds stubs of attachments that follow the document
+func multipartDocument(doc interface{}, atts []Attachment) ([]byte, error) {
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	stubs := map[string]json.RawMessage{}
+	if existing, ok := fields[attachmentsField]; ok && string(existing) != "null" {
+		if err = json.Unmarshal(existing, &stubs); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, a := range atts {
+		stub, err := json.Marshal(attachmentStub{Follows: true, ContentType: a.ContentType, Length: a.Length})
+		if err != nil {
+			return nil, err
+		}
+		stubs[a.Name] = stub
+	}
+
+	if fields[attachmentsField], err = json.Marshal(stubs); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(fields)
+}
+
+//writeMultipart - writes the document part followed by attachment parts
+func writeMultipart(w io.Writer, boundary string, data []byte, atts []Attachment) error {
+
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {mimeJSON}})
+	if err != nil {
+		return err
+	}
+
+	if _, err = part.Write(data); err != nil {
+		return err
+	}
+
+	for _, a := range atts {
+
+		if part, err = mw.CreatePart(attachmentHeader(a)); err != nil {
+			return err
+		}
+
+		if a.Body == nil {
+			continue
+		}
+
+		if _, err = io.CopyN(part, a.Body, a.Length); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+//multipartLength - computes the length of the request body, so it can be sent without chunked encoding
+func multipartLength(boundary string, data []byte, atts []Attachment) (int64, error) {
+
+	counter := &countingWriter{}
+	empty := make([]Attachment, len(atts))
+
+	for i, a := range atts {
+		empty[i] = Attachment{Name: a.Name, ContentType: a.ContentType, Length: a.Length}
+		counter.n += a.Length
+	}
+
+	if err := writeMultipart(counter, boundary, data, empty); err != nil {
+		return 0, err
+	}
+
+	return counter.n, nil
+}
+
+func attachmentHeader(a Attachment) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Disposition": {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+		"Content-Type":        {a.ContentType},
+		"Content-Length":      {strconv.FormatInt(a.Length, 10)},
+	}
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+//AttachmentPart - An attachment read from a multipart response, the content is streamed from the response body
+type AttachmentPart struct {
+	io.Reader
+	Name        string
+	ContentType string
+	Encoding    string
+	Length      int64
+}
+
+//MultipartDocument - A document with attachments returned as a multipart/related response
+type MultipartDocument struct {
+	*response.CouchStatus
+	rdr  io.ReadCloser
+	mr   *multipart.Reader
+	data []byte
+}
+
+/*GetWithAttachments - Gets a document with attachments. Attachments are not embedded in the document as base64 data,
+instead they are streamed one by one with NextAttachment after the document. If rev is empty, then the latest revision is returned.
+*/
+func (db *CouchDatabase) GetWithAttachments(ctx context.Context, id, rev string) (*MultipartDocument, error) {
+
+	if id == "" {
+		return nil, errEmptyDocumentID
+	}
+
+	qParams := map[string]string{"attachments": "true"}
+	if rev != "" {
+		qParams["rev"] = rev
+	}
+
+	endpoint := fmt.Sprintf("%s/%s", db.Name, documentPath(id))
+	rqb := request.NewRequestBuilder()
+	rq, err := rqb.WithEndpoint(endpoint).WithMethod(request.MethodGet).WithParameters(qParams).
+		WithHeaders(map[string]string{"Accept": mimeMultipart}).
+		Build(db.cli)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = response.CheckStatus(&rs, endpoint); err != nil {
+		rs.Rdr.Close()
+		return nil, err
+	}
+
+	doc := &MultipartDocument{CouchStatus: rs.CouchStatus, rdr: rs.Rdr}
+
+	mtype, params, err := mime.ParseMediaType(rs.Header.Get("Content-Type"))
+
+	//a document without attachments is returned as a plain json
+	if err != nil || !strings.HasPrefix(mtype, "multipart/") {
+		doc.data, err = ioutil.ReadAll(rs.Rdr)
+		return doc, err
+	}
+
+	doc.mr = multipart.NewReader(rs.Rdr, params["boundary"])
+
+	part, err := doc.mr.NextPart()
+	if err != nil {
+		rs.Rdr.Close()
+		return nil, err
+	}
+
+	if doc.data, err = ioutil.ReadAll(part); err != nil {
+		rs.Rdr.Close()
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+//Decode - Unmarshals the document into v
+func (d *MultipartDocument) Decode(v interface{}) error {
+	return json.Unmarshal(d.data, v)
+}
+
+/*NextAttachment - Returns the next attachment, the content of the previous one is no longer available.
+Returns io.EOF when there are no more attachments.
+*/
+func (d *MultipartDocument) NextAttachment() (*AttachmentPart, error) {
+
+	if d.mr == nil {
+		return nil, io.EOF
+	}
+
+	part, err := d.mr.NextPart()
+	if err != nil {
+		return nil, err
+	}
+
+	att := &AttachmentPart{
+		Reader:      part,
+		ContentType: part.Header.Get("Content-Type"),
+		Encoding:    part.Header.Get("Content-Encoding"),
+		Length:      -1,
+	}
+
+	if _, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition")); err == nil {
+		att.Name = params["filename"]
+	}
+
+	if l, err := strconv.ParseInt(part.Header.Get("Content-Length"), 10, 64); err == nil {
+		att.Length = l
+	}
+
+	return att, nil
+}
+
+//Close - Closes the response body
+func (d *MultipartDocument) Close() error {
+	return d.rdr.Close()
+}
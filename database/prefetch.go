@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/przebro/couchdb/client"
+	"github.com/przebro/couchdb/cursor"
+)
+
+//prefetchedPage - a page fetched in the background or an error that stopped fetching
+type prefetchedPage struct {
+	result responseResult
+	err    error
+}
+
+/*prefetchCursor - buffers pages like the bufferedCursor, but requests next pages in the background
+while the current one is iterated. At most the configured number of pages is kept ahead of the reader.
+*/
+type prefetchCursor struct {
+	pages  chan prefetchedPage
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+	dec    *json.Decoder
+	meta   cursor.QueryMeta
+	warn   warningHandler
+	err    error
+}
+
+/*newPrefetchCursor - reads the first page and starts fetching following pages, fetching is stopped when the ctx is
+cancelled or the cursor is closed.
+*/
+func newPrefetchCursor(ctx context.Context, rdr io.ReadCloser, ep string, sel DataSelector, cli *client.CouchClient, warn warningHandler, ahead int) cursor.ResultCursor {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	result, err := getResultset(rdr)
+	if err != nil {
+		return &errorCursor{err: err}
+	}
+
+	/*
+		The fetcher holds one page while it waits for a free place in the buffer,
+		so the buffer is one page shorter to keep no more than ahead pages in memory
+	*/
+	fctx, cancel := context.WithCancel(ctx)
+	s := &prefetchCursor{pages: make(chan prefetchedPage, ahead-1), ctx: fctx, cancel: cancel, warn: warn}
+	s.err = s.setPage(result)
+
+	if s.err != nil || !checkDocuments(result.Data) {
+		cancel()
+		close(s.pages)
+		return s
+	}
+
+	s.wg.Add(1)
+	go s.fetch(fctx, ep, sel, cli, result.Bookmark)
+
+	return s
+}
+
+//fetch - fetches pages until an empty page, an error or cancellation
+func (s *prefetchCursor) fetch(ctx context.Context, ep string, sel DataSelector, cli *client.CouchClient, bookmark string) {
+
+	defer s.wg.Done()
+	defer close(s.pages)
+
+	for {
+		sel.Bookmark = bookmark
+
+		page := prefetchedPage{}
+		rdr, err := requestPage(ctx, cli, ep, sel)
+		if err == nil {
+			page.result, err = getResultset(rdr)
+		}
+		page.err = err
+
+		select {
+		case s.pages <- page:
+		case <-ctx.Done():
+			return
+		}
+
+		//an empty page is sent, so the meta is updated in the same way as by other cursors
+		if err != nil || !checkDocuments(page.result.Data) {
+			return
+		}
+
+		bookmark = page.result.Bookmark
+	}
+}
+
+func (s *prefetchCursor) setPage(result responseResult) error {
+
+	s.meta.AddPage(result.Bookmark, result.Warning, result.Stats)
+
+	s.dec = createDecoder(result.Data)
+	if _, err := s.dec.Token(); err != nil {
+		s.dec = nil
+	}
+
+	return s.warn.handle(result.Warning)
+}
+
+func (s *prefetchCursor) All(ctx context.Context, v interface{}) error {
+	return allDocuments(ctx, s, v)
+}
+
+func (s *prefetchCursor) Next(ctx context.Context) bool {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	for s.err == nil && s.dec != nil {
+
+		if s.dec.More() {
+			return true
+		}
+
+		select {
+		case page, ok := <-s.pages:
+			if !ok {
+				//fetching stops without sending a page, when the context of Select is done
+				s.err = s.ctx.Err()
+				s.dec = nil
+				return false
+			}
+			if page.err != nil {
+				s.err = page.err
+				return false
+			}
+			if s.err = s.setPage(page.result); s.err != nil {
+				return false
+			}
+		case <-ctx.Done():
+			s.err = ctx.Err()
+			return false
+		}
+	}
+
+	return false
+}
+
+func (s *prefetchCursor) Decode(v interface{}) error {
+	return s.dec.Decode(v)
+}
+
+func (s *prefetchCursor) Meta() cursor.QueryMeta {
+	return s.meta
+}
+
+//Close - stops fetching and waits until the background request is finished
+func (s *prefetchCursor) Close(ctx context.Context) error {
+
+	s.once.Do(func() {
+		s.cancel()
+		s.wg.Wait()
+		s.dec = nil
+	})
+
+	return nil
+}
+
+func (s *prefetchCursor) Err() error {
+	return s.err
+}
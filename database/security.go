@@ -68,6 +68,12 @@ func (db *CouchDatabase) setSecurity(ctx context.Context, data []byte) (*respons
 	}
 
 	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endpoint)
+
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 
 }
@@ -84,5 +90,11 @@ func (db *CouchDatabase) Security(ctx context.Context) (*response.CouchResult, e
 	}
 
 	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = response.CheckStatus(&rs, endpoint)
+
 	return response.NewResult(rs.CouchStatus, rs.Rdr), err
 }
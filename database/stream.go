@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/przebro/couchdb/client"
+	"github.com/przebro/couchdb/cursor"
+)
+
+const (
+	fieldDocs     = "docs"
+	fieldBookmark = "bookmark"
+	fieldWarning  = "warning"
+	fieldStats    = "execution_stats"
+)
+
+/*streamCursor - reads documents directly from a response body, only the current document is kept in memory.
+Fields that follow the docs array are read when the last document of a page is consumed, so the meta
+of a page is complete only after the page was iterated.
+*/
+type streamCursor struct {
+	rdr    io.ReadCloser
+	dec    *json.Decoder
+	ep     string
+	cli    *client.CouchClient
+	sel    DataSelector
+	meta   cursor.QueryMeta
+	page   responseResult
+	doc    json.RawMessage
+	warn   warningHandler
+	inDocs bool
+	count  int
+	err    error
+}
+
+func newStreamCursor(rdr io.ReadCloser, ep string, sel DataSelector, cli *client.CouchClient, warn warningHandler) cursor.ResultCursor {
+
+	s := &streamCursor{ep: ep, sel: sel, cli: cli, warn: warn}
+	if err := s.open(rdr); err != nil {
+		return &errorCursor{err: err}
+	}
+
+	return s
+}
+
+//open - starts reading a page, reads fields until the beginning of the docs array
+func (s *streamCursor) open(rdr io.ReadCloser) error {
+
+	s.rdr = rdr
+	s.dec = json.NewDecoder(rdr)
+	s.page = responseResult{}
+	s.count = 0
+
+	if err := expectDelim(s.dec, '{'); err != nil {
+		s.rdr.Close()
+		return err
+	}
+
+	if err := s.readFields(); err != nil {
+		s.rdr.Close()
+		return err
+	}
+
+	return nil
+}
+
+//readFields - reads fields of a page until the docs array or the end of the object
+func (s *streamCursor) readFields() error {
+
+	for s.dec.More() {
+
+		field, err := s.dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch field {
+		case fieldDocs:
+			if err = expectDelim(s.dec, '['); err != nil {
+				return err
+			}
+			s.inDocs = true
+			return nil
+		case fieldBookmark:
+			err = s.dec.Decode(&s.page.Bookmark)
+		case fieldWarning:
+			err = s.dec.Decode(&s.page.Warning)
+		case fieldStats:
+			err = s.dec.Decode(&s.page.Stats)
+		default:
+			err = s.dec.Decode(&json.RawMessage{})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := expectDelim(s.dec, '}'); err != nil {
+		return err
+	}
+
+	s.meta.AddPage(s.page.Bookmark, s.page.Warning, s.page.Stats)
+
+	return s.warn.handle(s.page.Warning)
+}
+
+//finish - reads the rest of a page after the docs array
+func (s *streamCursor) finish() error {
+
+	defer s.rdr.Close()
+
+	if err := expectDelim(s.dec, ']'); err != nil {
+		return err
+	}
+	s.inDocs = false
+
+	return s.readFields()
+}
+
+func (s *streamCursor) All(ctx context.Context, v interface{}) error {
+	return allDocuments(ctx, s, v)
+}
+
+func (s *streamCursor) Next(ctx context.Context) bool {
+
+	if s.err != nil || s.dec == nil {
+		return false
+	}
+
+	if s.inDocs && s.dec.More() {
+		s.doc = nil
+		if s.err = s.dec.Decode(&s.doc); s.err != nil {
+			s.rdr.Close()
+			return false
+		}
+		s.count++
+		return true
+	}
+
+	if s.inDocs {
+		if s.err = s.finish(); s.err != nil {
+			return false
+		}
+	}
+
+	//an empty page means that there are no more documents
+	if s.count == 0 {
+		s.Close(ctx)
+		return false
+	}
+
+	if !s.fetchNextResultset(ctx) {
+		return false
+	}
+
+	return s.Next(ctx)
+}
+
+func (s *streamCursor) fetchNextResultset(ctx context.Context) bool {
+
+	if s.cli == nil {
+		return false
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	s.sel.Bookmark = s.page.Bookmark
+	rdr, err := requestPage(ctx, s.cli, s.ep, s.sel)
+	if err != nil {
+		s.err = err
+		return false
+	}
+
+	if s.err = s.open(rdr); s.err != nil {
+		return false
+	}
+
+	return true
+}
+
+func (s *streamCursor) Decode(v interface{}) error {
+	return json.Unmarshal(s.doc, v)
+}
+
+func (s *streamCursor) Meta() cursor.QueryMeta {
+	return s.meta
+}
+
+func (s *streamCursor) Close(ctx context.Context) error {
+
+	s.dec = nil
+	s.doc = nil
+	s.cli = nil
+
+	if s.rdr == nil {
+		return nil
+	}
+
+	return s.rdr.Close()
+}
+
+func (s *streamCursor) Err() error {
+	return s.err
+}
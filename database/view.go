@@ -0,0 +1,296 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/przebro/couchdb/request"
+	"github.com/przebro/couchdb/response"
+)
+
+const (
+	endPointView = "_view"
+	fieldRows    = "rows"
+)
+
+//Values of the update parameter of a view
+const (
+	UpdateTrue  = "true"
+	UpdateFalse = "false"
+	UpdateLazy  = "lazy"
+)
+
+var (
+	errEmptyViewName  = errors.New("design document and view name are required")
+	errInvalidViewOpt = errors.New("keys cannot be combined with key, startkey or endkey")
+	errUnexpectedJSON = errors.New("unexpected json token")
+)
+
+/*ViewOptions - Parameters of a view query. Keys are encoded as JSON, so they can be of any type, e.g. a string, a number or an array.
+Nil means that the parameter is not set. If Keys are provided, then the query is sent with POST.
+*/
+type ViewOptions struct {
+	Key           interface{}
+	Keys          []interface{}
+	StartKey      interface{}
+	EndKey        interface{}
+	StartKeyDocID string
+	EndKeyDocID   string
+	Descending    bool
+	IncludeDocs   bool
+	InclusiveEnd  *bool
+	Reduce        *bool
+	Group         bool
+	GroupLevel    int
+	Limit         int
+	Skip          int
+	//Stale - ok or update_after, deprecated in CouchDB 3.x in favor of Update and Stable
+	Stale string
+	//Update - one of UpdateTrue, UpdateFalse, UpdateLazy
+	Update string
+	Stable *bool
+}
+
+func (o ViewOptions) params() (map[string]string, error) {
+
+	params := map[string]string{}
+
+	if o.Keys != nil && (o.Key != nil || o.StartKey != nil || o.EndKey != nil) {
+		return nil, errInvalidViewOpt
+	}
+
+	for name, key := range map[string]interface{}{"key": o.Key, "startkey": o.StartKey, "endkey": o.EndKey} {
+		if key == nil {
+			continue
+		}
+		data, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		params[name] = string(data)
+	}
+
+	if o.StartKeyDocID != "" {
+		params["startkey_docid"] = o.StartKeyDocID
+	}
+	if o.EndKeyDocID != "" {
+		params["endkey_docid"] = o.EndKeyDocID
+	}
+	if o.Descending {
+		params["descending"] = "true"
+	}
+	if o.IncludeDocs {
+		params["include_docs"] = "true"
+	}
+	if o.InclusiveEnd != nil {
+		params["inclusive_end"] = strconv.FormatBool(*o.InclusiveEnd)
+	}
+	if o.Reduce != nil {
+		params["reduce"] = strconv.FormatBool(*o.Reduce)
+	}
+	if o.Group {
+		params["group"] = "true"
+	}
+	if o.GroupLevel > 0 {
+		params["group_level"] = strconv.Itoa(o.GroupLevel)
+	}
+	if o.Limit > 0 {
+		params["limit"] = strconv.Itoa(o.Limit)
+	}
+	if o.Skip > 0 {
+		params["skip"] = strconv.Itoa(o.Skip)
+	}
+	if o.Stale != "" {
+		params["stale"] = o.Stale
+	}
+	if o.Update != "" {
+		params["update"] = o.Update
+	}
+	if o.Stable != nil {
+		params["stable"] = strconv.FormatBool(*o.Stable)
+	}
+
+	return params, nil
+}
+
+//ViewRow - A single row of a view. For a reduce view the ID and the Doc are empty
+type ViewRow struct {
+	ID    string          `json:"id"`
+	Key   json.RawMessage `json:"key"`
+	Value json.RawMessage `json:"value"`
+	Doc   json.RawMessage `json:"doc,omitempty"`
+	//Error - set when a key requested with Keys was not found
+	Error string `json:"error,omitempty"`
+}
+
+//DecodeKey - Unmarshals the key of the row
+func (r *ViewRow) DecodeKey(v interface{}) error {
+	return json.Unmarshal(r.Key, v)
+}
+
+//DecodeValue - Unmarshals the value of the row
+func (r *ViewRow) DecodeValue(v interface{}) error {
+	return json.Unmarshal(r.Value, v)
+}
+
+//DecodeDoc - Unmarshals the document of the row, available only if IncludeDocs is set
+func (r *ViewRow) DecodeDoc(v interface{}) error {
+	return json.Unmarshal(r.Doc, v)
+}
+
+//ViewResult - Iterates over rows of a view, rows are decoded one by one as they are read from the response
+type ViewResult struct {
+	*response.CouchStatus
+	//TotalRows - number of rows in the view, not available for reduce views
+	TotalRows int
+	//Offset - offset where the rows start, not available for reduce views
+	Offset int
+
+	rdr io.ReadCloser
+	dec *json.Decoder
+	row ViewRow
+	err error
+	end bool
+}
+
+//View - Queries a view of the design document, the ddoc may be given with or without the _design/ prefix
+func (db *CouchDatabase) View(ctx context.Context, ddoc, view string, opts ViewOptions) (*ViewResult, error) {
+
+	ddoc = strings.TrimPrefix(ddoc, designPrefix)
+
+	if ddoc == "" || view == "" {
+		return nil, errEmptyViewName
+	}
+
+	params, err := opts.params()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/%s%s/%s/%s", db.Name, designPrefix, documentPath(ddoc), endPointView, documentPath(view))
+	rqb := request.NewRequestBuilder().WithEndpoint(endpoint).WithParameters(params)
+
+	if opts.Keys != nil {
+		body, err := json.Marshal(map[string]interface{}{"keys": opts.Keys})
+		if err != nil {
+			return nil, err
+		}
+		rqb.WithMethod(request.MethodPost).WithBody(body).WithIdempotent(true)
+	} else {
+		rqb.WithMethod(request.MethodGet)
+	}
+
+	rq, err := rqb.Build(db.cli)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = response.CheckStatus(&rs, endpoint); err != nil {
+		rs.Rdr.Close()
+		return nil, err
+	}
+
+	result := &ViewResult{CouchStatus: rs.CouchStatus, rdr: rs.Rdr, dec: json.NewDecoder(rs.Rdr)}
+
+	if err = result.readHeader(); err != nil {
+		rs.Rdr.Close()
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//readHeader - reads fields preceding rows
+func (r *ViewResult) readHeader() error {
+
+	if err := expectDelim(r.dec, '{'); err != nil {
+		return err
+	}
+
+	for r.dec.More() {
+
+		field, err := r.dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch field {
+		case "total_rows":
+			err = r.dec.Decode(&r.TotalRows)
+		case "offset":
+			err = r.dec.Decode(&r.Offset)
+		case fieldRows:
+			return expectDelim(r.dec, '[')
+		default:
+			err = r.dec.Decode(&json.RawMessage{})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	//there are no rows at all
+	r.end = true
+	return nil
+}
+
+//Next - Reads the next row, returns false if there are no more rows or an error occurred, check Err to tell them apart
+func (r *ViewResult) Next() bool {
+
+	if r.end || r.err != nil {
+		return false
+	}
+
+	if !r.dec.More() {
+		r.end = true
+		return false
+	}
+
+	r.row = ViewRow{}
+	if r.err = r.dec.Decode(&r.row); r.err != nil {
+		return false
+	}
+
+	return true
+}
+
+//Row - Returns the current row
+func (r *ViewResult) Row() ViewRow {
+	return r.row
+}
+
+//Err - Returns an error that stopped the iteration
+func (r *ViewResult) Err() error {
+	return r.err
+}
+
+//Close - Closes the response body
+func (r *ViewResult) Close() error {
+	r.end = true
+	return r.rdr.Close()
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+
+	tkn, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if d, ok := tkn.(json.Delim); !ok || d != delim {
+		return errUnexpectedJSON
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/przebro/couchdb/client"
+)
+
+//noIndexWarning - a part of a warning returned when a query is executed without an index
+const noIndexWarning = "no matching index found"
+
+//ErrQueryWarning - A query returned a warning and the warning policy is client.WarningError
+var ErrQueryWarning = errors.New("query returned a warning")
+
+//QueryWarningError - Returned when the warning policy is client.WarningError, matches ErrQueryWarning
+type QueryWarningError struct {
+	Database string
+	Selector json.RawMessage
+	Warning  string
+}
+
+func (e *QueryWarningError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Database, ErrQueryWarning, e.Warning)
+}
+
+//Is - Matches ErrQueryWarning
+func (e *QueryWarningError) Is(target error) bool {
+	return target == ErrQueryWarning
+}
+
+//NoIndex - Returns true if the query was executed without an index
+func (e *QueryWarningError) NoIndex() bool {
+	return isNoIndexWarning(e.Warning)
+}
+
+//warningHandler - applies a warning policy to a warning of a query, it is called at most once for a cursor
+type warningHandler func(warning string) error
+
+func (h *warningHandler) handle(warning string) error {
+
+	if warning == "" || *h == nil {
+		return nil
+	}
+
+	fn := *h
+	*h = nil
+
+	return fn(warning)
+}
+
+//SetWarningPolicy - Sets a policy of warnings returned by queries of this database, it overrides a policy of a connection
+func (db *CouchDatabase) SetWarningPolicy(policy client.WarningPolicy) {
+	db.warning = &policy
+}
+
+//warningHandler - creates a handler for a query according to the policy of the database or the client
+func (db *CouchDatabase) warningHandler(ctx context.Context, query DataSelector) warningHandler {
+
+	var policy client.WarningPolicy
+	if db.warning != nil {
+		policy = *db.warning
+	} else if db.cli != nil {
+		policy = db.cli.WarningPolicy()
+	}
+
+	if policy.Mode == client.WarningIgnore {
+		return nil
+	}
+
+	return func(warning string) error {
+
+		qw := client.QueryWarning{Database: db.Name, Selector: query.Selector, Warning: warning}
+
+		switch policy.Mode {
+		case client.WarningError:
+			{
+				if policy.Hook != nil {
+					policy.Hook(ctx, qw)
+				}
+				return &QueryWarningError{Database: db.Name, Selector: query.Selector, Warning: warning}
+			}
+		case client.WarningCreateIndex:
+			{
+				if isNoIndexWarning(warning) {
+					qw.Index, qw.Err = db.createSuggestedIndex(ctx, query)
+				}
+			}
+		}
+
+		if policy.Hook != nil {
+			policy.Hook(ctx, qw)
+		}
+
+		return nil
+	}
+}
+
+/*createSuggestedIndex - creates a json index for fields used by the sort and the selector of a query. An index is not
+created when fields cannot be determined e.g. the selector contains only $or.
+*/
+func (db *CouchDatabase) createSuggestedIndex(ctx context.Context, query DataSelector) (string, error) {
+
+	fields, err := suggestIndexFields(query)
+	if err != nil || len(fields) == 0 {
+		return "", err
+	}
+
+	result, err := db.Index(ctx, IndexDefinition{Type: IndexTypeJSON, Fields: fields})
+	if err != nil {
+		return "", err
+	}
+
+	return result.Name, nil
+}
+
+//suggestIndexFields - returns sort fields followed by fields of the selector in the alphabetical order
+func suggestIndexFields(query DataSelector) ([]IndexField, error) {
+
+	selector := map[string]interface{}{}
+	if err := json.Unmarshal(query.Selector, &selector); err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+	selectorFields("", selector, found)
+
+	fields := []IndexField{}
+	for _, s := range query.Sort {
+		fields = append(fields, IndexField{Name: s.Field, Direction: s.Direction})
+		delete(found, s.Field)
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fields = append(fields, IndexField{Name: name})
+	}
+
+	return fields, nil
+}
+
+/*selectorFields - collects paths of fields that every document matching the selector must satisfy, so fields of $and are
+included and fields of other combination operators are skipped
+*/
+func selectorFields(prefix string, selector map[string]interface{}, found map[string]bool) {
+
+	for key, value := range selector {
+
+		if key == "$and" {
+			if list, ok := value.([]interface{}); ok {
+				for _, item := range list {
+					if sub, ok := item.(map[string]interface{}); ok {
+						selectorFields(prefix, sub, found)
+					}
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(key, "$") {
+			continue
+		}
+
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		//an object without operators is a nested field, otherwise the value is a condition of the field
+		if sub, ok := value.(map[string]interface{}); ok && !hasOperator(sub) {
+			selectorFields(path, sub, found)
+			continue
+		}
+
+		found[path] = true
+	}
+}
+
+func hasOperator(m map[string]interface{}) bool {
+	for key := range m {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+func isNoIndexWarning(warning string) bool {
+	return strings.Contains(strings.ToLower(warning), noIndexWarning)
+}
@@ -3,10 +3,15 @@ package request
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/przebro/couchdb/client"
 	"github.com/przebro/couchdb/response"
@@ -26,30 +31,254 @@ const (
 	MethodCopy CouchMethod = "COPY"
 )
 
+const endPointSession = "_session"
+
 var (
-	errRequest = errors.New("request error")
+	errRequest      = errors.New("request error")
+	errBodyConsumed = errors.New("request body has already been sent and cannot be read again")
 )
 
 //CouchRequest - Wraps http request
 type CouchRequest struct {
-	cli     *http.Client
-	request *http.Request
+	cli        *client.CouchClient
+	method     string
+	path       string
+	headers    http.Header
+	body       []byte
+	stream     io.Reader
+	length     int64
+	offset     int64
+	sent       bool
+	idempotent bool
 }
 
-//Execute - executes request
+/*Execute - executes request. If the request fails with a transient error, then it is repeated according to
+the retry policy of the client, as long as the context is not done. If the client is connected to a cluster,
+then the request is sent to a node chosen by the selector and idempotent requests fail over to other nodes.
+*/
 func (req *CouchRequest) Execute(ctx context.Context) (response.CouchResponse, error) {
 
-	var err error = nil
-	var couchResponse response.CouchResponse
-
 	if ctx == nil {
 		ctx = context.Background()
 	}
 
-	req.request.Body.Close()
-	req.request = req.request.WithContext(ctx)
+	policy := req.cli.RetryPolicy()
+
+	if !req.replayable() {
+		policy = client.RetryPolicy{}
+	}
+
+	for attempt := 1; ; attempt++ {
+
+		couchResponse, err := req.failover(ctx, policy)
+
+		if attempt >= policy.MaxAttempts || !req.retryable(policy, couchResponse, err) {
+			return couchResponse, unwrapTransport(err)
+		}
+
+		delay := policy.Backoff(attempt)
+		if after := retryAfter(couchResponse); after > delay {
+			delay = after
+		}
+
+		//there is no point in waiting if the context will be done before the next attempt
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return couchResponse, unwrapTransport(err)
+		}
+
+		if err == nil {
+			couchResponse.Rdr.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			{
+				timer.Stop()
+				return couchResponse, ctx.Err()
+			}
+		case <-timer.C:
+			{
+			}
+		}
+	}
+}
+
+//retryable - checks if the request may be repeated after the failed attempt
+func (req *CouchRequest) retryable(policy client.RetryPolicy, rs response.CouchResponse, err error) bool {
+
+	if !req.idempotent && !policy.RetryableMethod(req.method) {
+		return false
+	}
+
+	//only a failure of the network may go away, errors of building or authorizing the request will be the same next time
+	if err != nil {
+		return isTransportError(err)
+	}
+
+	return policy.RetryableStatus(rs.Code)
+}
+
+/*failover - executes an attempt on a node chosen from the pool. If the node is not reachable, then it is marked as unhealthy
+and an idempotent request is sent to the next node. A response with a status that the policy allows to retry is also passed
+to the next node. Any response marks the node as healthy again, so a node recovers without the health check.
+Other errors are returned at once, because they do not depend on the node.
+*/
+func (req *CouchRequest) failover(ctx context.Context, policy client.RetryPolicy) (response.CouchResponse, error) {
+
+	if node, ok := client.NodeFromContext(ctx); ok {
+		return req.attempt(ctx, node.Addr)
+	}
+
+	pool := req.cli.Nodes()
+	if pool == nil {
+		return req.attempt(ctx, req.cli.BaseAddr)
+	}
+
+	tried := map[*client.Node]bool{}
+	node := pool.Next(tried)
+	if node == nil {
+		return req.attempt(ctx, req.cli.BaseAddr)
+	}
+
+	//the same methods are repeated on the next node as by the retry
+	idempotent := (req.idempotent || policy.RetryableMethod(req.method)) && req.replayable()
+
+	for {
+		couchResponse, err := req.attempt(ctx, node.Addr)
+
+		switch {
+		case isTransportError(err):
+			node.SetHealthy(false)
+		case err != nil:
+			return couchResponse, err
+		default:
+			node.SetHealthy(true)
+			if !policy.RetryableStatus(couchResponse.Code) {
+				return couchResponse, nil
+			}
+		}
+
+		tried[node] = true
 
-	rc, e := req.execute(req.request)
+		next := pool.Next(tried)
+		if next == nil || !idempotent {
+			return couchResponse, err
+		}
+
+		if err == nil {
+			couchResponse.Rdr.Close()
+		}
+
+		node = next
+	}
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+//transportError - an error returned by the http client, it means that a request was not delivered or a response was lost
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+//isTransportError - checks if the error was returned by the http client and it was not caused by the context
+func isTransportError(err error) bool {
+	var terr *transportError
+	return errors.As(err, &terr) && !isContextError(err)
+}
+
+//unwrapTransport - returns the original error of the http client, so callers receive the same error as from http.Client
+func unwrapTransport(err error) error {
+	var terr *transportError
+	if errors.As(err, &terr) {
+		return terr.err
+	}
+	return err
+}
+
+//retryAfter - returns a delay requested by the server in the Retry-After header
+func retryAfter(rs response.CouchResponse) time.Duration {
+
+	if rs.Header == nil {
+		return 0
+	}
+
+	value := rs.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
+
+//attempt - executes request once, if the session or the token has expired, then the request is replayed with new credentials
+func (req *CouchRequest) attempt(ctx context.Context, base string) (response.CouchResponse, error) {
+
+	couchResponse, credential, err := req.send(ctx, base)
+	if err != nil {
+		return couchResponse, err
+	}
+
+	if couchResponse.Code != response.StatusCode401Unauthorized || req.isSession() || !req.replayable() {
+		return couchResponse, nil
+	}
+
+	/*
+		The session or the token has probably expired, renew it and replay the request once.
+		If it is not possible, the original response is returned.
+	*/
+	renewed := false
+
+	switch req.cli.Authentication {
+	case client.Cookie:
+		renewed = req.renewSession(ctx, base, credential)
+	case client.JwtToken:
+		renewed = req.cli.InvalidateToken(credential)
+	}
+
+	if !renewed {
+		return couchResponse, nil
+	}
+
+	couchResponse.Rdr.Close()
+
+	couchResponse, _, err = req.send(ctx, base)
+
+	return couchResponse, err
+}
+
+/*send - builds a new http request from stored data and executes it. It returns also the credential used to authenticate
+the request: a session cookie or a token
+*/
+func (req *CouchRequest) send(ctx context.Context, base string) (response.CouchResponse, string, error) {
+
+	var err error = nil
+	var couchResponse response.CouchResponse
+
+	rq, credential, err := req.newRequest(ctx, base)
+	if err != nil {
+		return couchResponse, credential, err
+	}
+
+	rc, e := req.execute(rq)
 
 	select {
 	case couchResponse = <-rc:
@@ -66,56 +295,253 @@ func (req *CouchRequest) Execute(ctx context.Context) (response.CouchResponse, e
 
 	}
 
-	return couchResponse, err
+	return couchResponse, credential, err
 }
+
 func (req *CouchRequest) execute(rq *http.Request) (<-chan response.CouchResponse, <-chan error) {
 
 	ch := make(chan response.CouchResponse, 1)
 	e := make(chan error, 1)
 	go func(<-chan response.CouchResponse, <-chan error) {
 
-		rs, err := req.cli.Do(rq)
+		couchResponse, err := req.cli.Chain(req.do)(rq)
 
 		if err != nil {
 			e <- err
 			return
 		}
 
-		couchResponse := response.CouchResponse{CouchStatus: &response.CouchStatus{}}
-		couchResponse.Code = rs.StatusCode
-		couchResponse.Status = rs.Status
-		couchResponse.Server = rs.Header.Get("Server")
-		couchResponse.Rdr = rs.Body
+		ch <- couchResponse
+
+	}(ch, e)
+
+	return ch, e
+}
 
-		ck := rs.Cookies()
+//do - sends a request, it is the last handler in the middleware chain
+func (req *CouchRequest) do(rq *http.Request) (response.CouchResponse, error) {
 
-		if len(ck) > 0 {
-			couchResponse.Cookie = *ck[0]
+	rs, err := req.cli.Client.Do(rq)
+
+	if err != nil {
+		return response.CouchResponse{}, &transportError{err: err}
+	}
+
+	couchResponse := response.CouchResponse{CouchStatus: &response.CouchStatus{}}
+	couchResponse.Code = rs.StatusCode
+	couchResponse.Status = rs.Status
+	couchResponse.Server = rs.Header.Get("Server")
+	couchResponse.Rdr = rs.Body
+	couchResponse.Header = rs.Header
+
+	ck := rs.Cookies()
+
+	if len(ck) > 0 {
+		couchResponse.Cookie = *ck[0]
+	}
+
+	/*
+		CouchDB refreshes a session cookie when the session is about to expire,
+		pick up the new value so the following requests will use it
+	*/
+	if req.cli.Authentication == client.Cookie {
+		for _, c := range ck {
+			if c.Name == client.SessionCookieName && c.Value != "" {
+				req.cli.SetAuthData(c.Value)
+			}
 		}
+	}
 
-		ch <- couchResponse
+	return couchResponse, nil
+}
 
-	}(ch, e)
+//newRequest - creates a new http request, every attempt requires a new instance with a fresh body and authentication data
+func (req *CouchRequest) newRequest(ctx context.Context, base string) (*http.Request, string, error) {
 
-	return ch, e
+	body, err := req.newBody()
+	if err != nil {
+		return nil, "", err
+	}
+
+	rq, err := http.NewRequest(req.method, fmt.Sprintf(`%s/%s`, base, req.path), body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rq = rq.WithContext(ctx)
+
+	if req.stream != nil && req.length > 0 {
+		rq.ContentLength = req.length
+	}
+
+	for k, v := range req.headers {
+		rq.Header[k] = v
+	}
+
+	credential, err := req.authorize(ctx, rq)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return rq, credential, nil
+}
+
+//replayable - checks if the body can be sent again, a stream can be sent again only if it is seekable
+func (req *CouchRequest) replayable() bool {
+	if req.stream == nil {
+		return true
+	}
+	_, ok := req.stream.(io.Seeker)
+	return ok
+}
+
+//newBody - returns a body for the next attempt, a seekable stream is rewound to its initial position
+func (req *CouchRequest) newBody() (io.Reader, error) {
+
+	if req.stream == nil {
+		return bytes.NewReader(req.body), nil
+	}
+
+	if seeker, ok := req.stream.(io.Seeker); ok {
+		if _, err := seeker.Seek(req.offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return req.stream, nil
+	}
+
+	if req.sent {
+		return nil, errBodyConsumed
+	}
+
+	req.sent = true
+
+	return req.stream, nil
+}
+
+//authorize - adds authentication data to the request
+func (req *CouchRequest) authorize(ctx context.Context, rq *http.Request) (string, error) {
+
+	cli := req.cli
+
+	switch cli.Authentication {
+	case client.JwtToken:
+		{
+			token, err := cli.Token(ctx)
+			if err != nil {
+				return "", err
+			}
+			rq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+			return token, nil
+		}
+	case client.Basic:
+		{
+			data := cli.GetAuthData()
+			rq.Header.Add("Authorization", fmt.Sprintf("Basic %s", data))
+			return data, nil
+		}
+	case client.Proxy:
+		{
+			user := cli.ProxyUser(ctx)
+			rq.Header.Set(client.ProxyUserNameHeader, user.Name)
+			if len(user.Roles) > 0 {
+				rq.Header.Set(client.ProxyRolesHeader, strings.Join(user.Roles, ","))
+			}
+			if token := cli.ProxyToken(user.Name); token != "" {
+				rq.Header.Set(client.ProxyTokenHeader, token)
+			}
+			return user.Name, nil
+		}
+	case client.Cookie:
+		{
+			data := cli.GetAuthData()
+			if !req.isSession() {
+				rq.AddCookie(&http.Cookie{Name: client.SessionCookieName, Value: data})
+			}
+			return data, nil
+		}
+	}
+
+	return "", nil
+}
+
+func (req *CouchRequest) isSession() bool {
+	return strings.SplitN(req.path, "?", 2)[0] == endPointSession
+}
+
+/*renewSession - establishes a new session with stored credentials. The usedData is a cookie value sent with a failed request,
+if it differs from the current one, then some other request has already renewed the session.
+*/
+func (req *CouchRequest) renewSession(ctx context.Context, base, usedData string) bool {
+
+	cli := req.cli
+
+	cli.LockRenewal()
+	defer cli.UnlockRenewal()
+
+	if cli.GetAuthData() != usedData {
+		return true
+	}
+
+	username, password := cli.Credentials()
+	if username == "" && password == "" {
+		return false
+	}
+
+	body := struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}{
+		Name:     username,
+		Password: password,
+	}
+
+	doc, err := json.Marshal(&body)
+	if err != nil {
+		return false
+	}
+
+	rq, err := NewRequestBuilder().WithEndpoint(endPointSession).WithMethod(MethodPost).WithBody(doc).Build(cli)
+	if err != nil {
+		return false
+	}
+
+	rs, _, err := rq.send(ctx, base)
+	if err != nil {
+		return false
+	}
+	defer rs.Rdr.Close()
+
+	if rs.Code >= response.StatusCode400BadRequest || rs.Cookie.Value == "" {
+		return false
+	}
+
+	cli.SetAuthData(rs.Cookie.Value)
+
+	return true
 }
 
 //Builder - Helps build a new CouchDB request
 type Builder interface {
 	WithBody(doc []byte) Builder
+	WithBodyReader(rdr io.Reader) Builder
+	WithContentLength(length int64) Builder
 	WithMethod(method CouchMethod) Builder
 	WithParameters(params map[string]string) Builder
 	WithHeaders(headers map[string]string) Builder
 	WithEndpoint(endpoint string) Builder
+	WithIdempotent(idempotent bool) Builder
 	Build(conn *client.CouchClient) (*CouchRequest, error)
 }
 
 type requestBuilder struct {
-	endpoint string
-	method   CouchMethod
-	params   map[string]string
-	headers  map[string]string
-	body     []byte
+	endpoint   string
+	method     CouchMethod
+	params     map[string]string
+	headers    map[string]string
+	body       []byte
+	stream     io.Reader
+	length     int64
+	idempotent bool
 }
 
 //NewRequestBuilder - Creates a new instance of RequestBuilder
@@ -127,6 +553,22 @@ func NewRequestBuilder() Builder {
 
 func (rb *requestBuilder) WithBody(doc []byte) Builder {
 	rb.body = doc
+	rb.stream = nil
+	return rb
+}
+
+/*WithBodyReader - Sets a body that is streamed to the server instead of being buffered. If the reader is not an io.Seeker,
+then the request cannot be repeated, so it is sent only once regardless of the retry policy.
+*/
+func (rb *requestBuilder) WithBodyReader(rdr io.Reader) Builder {
+	rb.stream = rdr
+	rb.body = nil
+	return rb
+}
+
+//WithContentLength - Sets a length of a body set by WithBodyReader, without it the body is sent with chunked encoding
+func (rb *requestBuilder) WithContentLength(length int64) Builder {
+	rb.length = length
 	return rb
 }
 func (rb *requestBuilder) WithMethod(method CouchMethod) Builder {
@@ -154,9 +596,16 @@ func (rb *requestBuilder) WithEndpoint(endpoint string) Builder {
 	rb.endpoint = endpoint
 	return rb
 }
+/*WithIdempotent - Marks a request as safe to repeat regardless of its method e.g. POST to _find endpoint
+only reads data, so it can be repeated as well as GET
+*/
+func (rb *requestBuilder) WithIdempotent(idempotent bool) Builder {
+	rb.idempotent = idempotent
+	return rb
+}
+
 func (rb *requestBuilder) Build(cli *client.CouchClient) (*CouchRequest, error) {
 
-	var err error
 	var method string
 
 	switch rb.method {
@@ -170,45 +619,36 @@ func (rb *requestBuilder) Build(cli *client.CouchClient) (*CouchRequest, error)
 		}
 	}
 
-	endp := fmt.Sprintf(`%s/%s`, cli.BaseAddr, rb.endpoint)
+	endp := rb.endpoint
 
-	if rb.params != nil {
-		params := []string{}
+	//values are escaped, so they can contain JSON e.g. a key of a view
+	if len(rb.params) > 0 {
+		params := url.Values{}
 		for k, v := range rb.params {
-			params = append(params, fmt.Sprintf("%s=%s", k, v))
+			params.Set(k, v)
 		}
-		qstring := strings.Join(params, "&")
-		endp = fmt.Sprintf("%s?%s", endp, qstring)
+		endp = fmt.Sprintf("%s?%s", endp, params.Encode())
 	}
 
-	r := &CouchRequest{}
-	r.cli = cli.Client
+	r := &CouchRequest{cli: cli, method: method, path: endp, body: rb.body, stream: rb.stream, length: rb.length, headers: http.Header{}, idempotent: rb.idempotent}
 
-	bodyRdr := bytes.NewReader(rb.body)
-	r.request, err = http.NewRequest(method, endp, bodyRdr)
+	if seeker, ok := rb.stream.(io.Seeker); ok {
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		r.offset = offset
+	}
 
-	if err != nil {
+	//validate request data before it will be sent
+	if _, err := http.NewRequest(method, fmt.Sprintf(`%s/%s`, cli.BaseAddr, endp), nil); err != nil {
 		return nil, err
 	}
 
-	r.request.Header.Add("Content-Type", "application/json")
-
-	if cli.Authentication == client.JwtToken {
-		data := fmt.Sprintf("Bearer %s", cli.AuthData)
-		r.request.Header.Add("Authorization", data)
-	}
-	if cli.Authentication == client.Basic {
-		data := fmt.Sprintf("Basic %s", cli.AuthData)
-		r.request.Header.Add("Authorization", data)
-	}
+	r.headers.Set("Content-Type", "application/json")
 
 	for k, v := range rb.headers {
-		r.request.Header.Add(k, v)
-	}
-
-	if cli.Authentication == client.Cookie {
-		ck := &http.Cookie{Name: "AuthSession", Value: cli.AuthData}
-		r.request.AddCookie(ck)
+		r.headers.Set(k, v)
 	}
 
 	return r, nil
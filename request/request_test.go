@@ -0,0 +1,518 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/przebro/couchdb/client"
+	"github.com/przebro/couchdb/response"
+)
+
+func newTestClient(srv *httptest.Server, auth client.AuthType) *client.CouchClient {
+	return &client.CouchClient{BaseAddr: srv.URL, Authentication: auth, Client: srv.Client()}
+}
+
+func TestSessionRenewal(t *testing.T) {
+
+	var sessions int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path == "/_session" {
+			atomic.AddInt32(&sessions, 1)
+			http.SetCookie(w, &http.Cookie{Name: client.SessionCookieName, Value: "renewed"})
+			w.Write([]byte(`{"ok":true}`))
+			return
+		}
+
+		ck, err := r.Cookie(client.SessionCookieName)
+		if err != nil || ck.Value != "renewed" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: client.SessionCookieName, Value: "renewed"})
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(srv, client.Cookie)
+	cli.SetAuthData("expired")
+	cli.SetCredentials("admin", "notsecure")
+
+	rq, err := NewRequestBuilder().WithEndpoint("db").WithMethod(MethodGet).Build(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := rq.Execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rs.Code != http.StatusOK {
+		t.Error("unexpected result:", rs.Code)
+	}
+
+	if atomic.LoadInt32(&sessions) != 1 {
+		t.Error("unexpected result:", sessions)
+	}
+
+	if cli.GetAuthData() != "renewed" {
+		t.Error("unexpected result:", cli.GetAuthData())
+	}
+
+	rs, err = rq.Execute(context.Background())
+	if err != nil || rs.Code != http.StatusOK {
+		t.Error("unexpected result:", err)
+	}
+
+	if atomic.LoadInt32(&sessions) != 1 {
+		t.Error("unexpected result:", sessions)
+	}
+}
+
+func TestSessionRenewalWithoutCredentials(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(srv, client.Cookie)
+
+	rq, _ := NewRequestBuilder().WithEndpoint("db").WithMethod(MethodGet).Build(cli)
+	rs, err := rq.Execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rs.Code != http.StatusUnauthorized {
+		t.Error("unexpected result:", rs.Code)
+	}
+}
+
+func TestProxyAuthentication(t *testing.T) {
+
+	headers := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(srv, client.Proxy)
+	cli.SetProxyUser(client.ProxyUser{Name: "gateway", Roles: []string{"_admin", "reader"}}, "secret")
+
+	rq, _ := NewRequestBuilder().WithEndpoint("db").WithMethod(MethodGet).Build(cli)
+	if _, err := rq.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	h := <-headers
+	if h.Get(client.ProxyUserNameHeader) != "gateway" {
+		t.Error("unexpected result:", h.Get(client.ProxyUserNameHeader))
+	}
+	if h.Get(client.ProxyRolesHeader) != "_admin,reader" {
+		t.Error("unexpected result:", h.Get(client.ProxyRolesHeader))
+	}
+	//hmac-sha1 of "gateway" with key "secret"
+	if h.Get(client.ProxyTokenHeader) != "a5ad3b4de73779b8f3d5d96c0143e6238ad6280b" {
+		t.Error("unexpected result:", h.Get(client.ProxyTokenHeader))
+	}
+
+	ctx := client.WithProxyUser(context.Background(), "enduser")
+	if _, err := rq.Execute(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	h = <-headers
+	if h.Get(client.ProxyUserNameHeader) != "enduser" {
+		t.Error("unexpected result:", h.Get(client.ProxyUserNameHeader))
+	}
+	if h.Get(client.ProxyRolesHeader) != "" {
+		t.Error("unexpected result:", h.Get(client.ProxyRolesHeader))
+	}
+	if h.Get(client.ProxyTokenHeader) == cli.ProxyToken("gateway") {
+		t.Error("unexpected result")
+	}
+}
+
+func TestTokenSource(t *testing.T) {
+
+	var issued int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token_1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(srv, client.JwtToken)
+	cli.SetTokenSource(client.TokenSourceFunc(func(ctx context.Context) (string, time.Time, error) {
+		n := atomic.AddInt32(&issued, 1)
+		return fmt.Sprintf("token_%d", n), time.Now().Add(time.Hour), nil
+	}))
+
+	rq, _ := NewRequestBuilder().WithEndpoint("db").WithMethod(MethodGet).Build(cli)
+
+	//the first token is rejected, so the request should be replayed with a new one
+	rs, err := rq.Execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Code != http.StatusOK {
+		t.Error("unexpected result:", rs.Code)
+	}
+
+	//the second token is valid and cached
+	rs, err = rq.Execute(context.Background())
+	if err != nil || rs.Code != http.StatusOK {
+		t.Error("unexpected result:", err)
+	}
+
+	if atomic.LoadInt32(&issued) != 2 {
+		t.Error("unexpected result:", atomic.LoadInt32(&issued))
+	}
+
+	//a token that is about to expire is refreshed before the request is sent
+	cli.SetTokenSource(client.TokenSourceFunc(func(ctx context.Context) (string, time.Time, error) {
+		n := atomic.AddInt32(&issued, 1)
+		return fmt.Sprintf("token_%d", n), time.Now().Add(time.Second), nil
+	}))
+
+	rq.Execute(context.Background())
+	rq.Execute(context.Background())
+
+	if atomic.LoadInt32(&issued) != 4 {
+		t.Error("unexpected result:", atomic.LoadInt32(&issued))
+	}
+
+	cli.SetTokenSource(client.TokenSourceFunc(func(ctx context.Context) (string, time.Time, error) {
+		return "", time.Time{}, errors.New("provider unavailable")
+	}))
+
+	if _, err = rq.Execute(context.Background()); err == nil {
+		t.Error("unexpected result")
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) != `{"doc":1}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(srv, client.None)
+	policy := client.DefaultRetryPolicy()
+	policy.MinBackoff = time.Millisecond
+	cli.SetRetryPolicy(policy)
+
+	rq, _ := NewRequestBuilder().WithEndpoint("db/doc").WithMethod(MethodPut).WithBody([]byte(`{"doc":1}`)).Build(cli)
+
+	rs, err := rq.Execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rs.Code != http.StatusOK || atomic.LoadInt32(&calls) != 3 {
+		t.Error("unexpected result:", rs.Code, atomic.LoadInt32(&calls))
+	}
+
+	//POST is not idempotent, so it is not repeated unless it is marked as idempotent
+	atomic.StoreInt32(&calls, 0)
+	rq, _ = NewRequestBuilder().WithEndpoint("db").WithMethod(MethodPost).WithBody([]byte(`{"doc":1}`)).Build(cli)
+
+	rs, _ = rq.Execute(context.Background())
+	if rs.Code != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 1 {
+		t.Error("unexpected result:", rs.Code, atomic.LoadInt32(&calls))
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	rq, _ = NewRequestBuilder().WithEndpoint("db/_find").WithMethod(MethodPost).WithBody([]byte(`{"doc":1}`)).WithIdempotent(true).Build(cli)
+
+	rs, _ = rq.Execute(context.Background())
+	if rs.Code != http.StatusOK || atomic.LoadInt32(&calls) != 3 {
+		t.Error("unexpected result:", rs.Code, atomic.LoadInt32(&calls))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(srv, client.None)
+	cli.SetRetryPolicy(client.DefaultRetryPolicy())
+
+	rq, _ := NewRequestBuilder().WithEndpoint("db").WithMethod(MethodGet).Build(cli)
+
+	//the server asks to wait longer than the deadline allows, so the response is returned at once
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	rs, err := rq.Execute(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rs.Code != http.StatusTooManyRequests || atomic.LoadInt32(&calls) != 1 || time.Since(start) > 500*time.Millisecond {
+		t.Error("unexpected result:", rs.Code, atomic.LoadInt32(&calls))
+	}
+}
+
+func TestRetryErrors(t *testing.T) {
+
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	policy := client.DefaultRetryPolicy()
+	policy.MinBackoff = time.Millisecond
+
+	//an error of the token source is not a network failure, so the request is not repeated
+	cli := newTestClient(srv, client.JwtToken)
+	cli.SetRetryPolicy(policy)
+	cli.SetTokenSource(client.TokenSourceFunc(func(ctx context.Context) (string, time.Time, error) {
+		atomic.AddInt32(&calls, 1)
+		return "", time.Time{}, errors.New("provider unavailable")
+	}))
+
+	rq, _ := NewRequestBuilder().WithEndpoint("db").WithMethod(MethodGet).Build(cli)
+	if _, err := rq.Execute(context.Background()); err == nil || atomic.LoadInt32(&calls) != 1 {
+		t.Error("unexpected result:", err, atomic.LoadInt32(&calls))
+	}
+
+	//a node that does not accept connections is retried
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	cli = newTestClient(down, client.None)
+	atomic.StoreInt32(&calls, 0)
+	cli.Use(func(next client.Handler) client.Handler {
+		return func(rq *http.Request) (response.CouchResponse, error) {
+			atomic.AddInt32(&calls, 1)
+			return next(rq)
+		}
+	})
+	cli.SetRetryPolicy(policy)
+
+	rq, _ = NewRequestBuilder().WithEndpoint("db").WithMethod(MethodGet).Build(cli)
+	_, err := rq.Execute(context.Background())
+
+	var uerr *url.Error
+	if !errors.As(err, &uerr) || atomic.LoadInt32(&calls) != int32(policy.MaxAttempts) {
+		t.Error("unexpected result:", err, atomic.LoadInt32(&calls))
+	}
+
+	if _, ok := err.(*url.Error); !ok {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestFailover(t *testing.T) {
+
+	var calls int32
+
+	busy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer busy.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	//a node that responds with a retryable status is skipped, but it remains healthy
+	first, second := client.NewNode(busy.URL), client.NewNode(srv.URL)
+	cli := newTestClient(srv, client.None)
+	cli.SetRetryPolicy(client.DefaultRetryPolicy())
+	cli.SetNodes(client.NewNodePool(nil, first, second))
+
+	rq, _ := NewRequestBuilder().WithEndpoint("db").WithMethod(MethodGet).Build(cli)
+	rs, err := rq.Execute(context.Background())
+	if err != nil || rs.Code != http.StatusOK || atomic.LoadInt32(&calls) != 1 {
+		t.Error("unexpected result:", err, atomic.LoadInt32(&calls))
+	}
+
+	if !first.Healthy() || !second.Healthy() {
+		t.Error("unexpected result")
+	}
+
+	//an error of the token source does not depend on the node
+	cli.Authentication = client.JwtToken
+	cli.SetTokenSource(client.TokenSourceFunc(func(ctx context.Context) (string, time.Time, error) {
+		return "", time.Time{}, errors.New("provider unavailable")
+	}))
+
+	if _, err = rq.Execute(context.Background()); err == nil {
+		t.Error("unexpected result")
+	}
+
+	if !first.Healthy() || !second.Healthy() {
+		t.Error("unexpected result")
+	}
+
+	//a node recovers after the cooldown without the health check
+	var primary, secondary int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primary, 1)
+		w.Write([]byte(`{}`))
+	}))
+	defer up.Close()
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&secondary, 1)
+		w.Write([]byte(`{}`))
+	}))
+	defer fallback.Close()
+
+	first, second = client.NewNode(up.URL), client.NewNode(fallback.URL)
+	pool := client.NewNodePool(nil, first, second)
+	pool.SetCooldown(50 * time.Millisecond)
+
+	cli = newTestClient(srv, client.None)
+	cli.SetNodes(pool)
+	first.SetHealthy(false)
+
+	rq, _ = NewRequestBuilder().WithEndpoint("db").WithMethod(MethodGet).Build(cli)
+	rq.Execute(context.Background())
+
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		rq.Execute(context.Background())
+	}
+
+	if atomic.LoadInt32(&primary) != 5 || atomic.LoadInt32(&secondary) != 1 || !first.Healthy() {
+		t.Error("unexpected result:", atomic.LoadInt32(&primary), atomic.LoadInt32(&secondary))
+	}
+
+	//methods that the policy does not repeat are not sent to the next node
+	atomic.StoreInt32(&calls, 0)
+	policy := client.DefaultRetryPolicy()
+	policy.Methods = []string{http.MethodGet}
+
+	cli = newTestClient(srv, client.None)
+	cli.SetRetryPolicy(policy)
+	cli.SetNodes(client.NewNodePool(nil, client.NewNode(busy.URL), client.NewNode(srv.URL)))
+
+	rq, _ = NewRequestBuilder().WithEndpoint("db/doc").WithMethod(MethodDelete).Build(cli)
+	if rs, err = rq.Execute(context.Background()); err != nil || rs.Code != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 1 {
+		t.Error("unexpected result:", err, atomic.LoadInt32(&calls))
+	}
+
+	//an empty pool falls back to the BaseAddr
+	cli = newTestClient(srv, client.None)
+	cli.SetNodes(client.NewNodePool(nil))
+
+	rq, _ = NewRequestBuilder().WithEndpoint("db").WithMethod(MethodGet).Build(cli)
+	if rs, err = rq.Execute(context.Background()); err != nil || rs.Code != http.StatusOK {
+		t.Error("unexpected result:", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+
+	policy := client.RetryPolicy{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		delay := policy.Backoff(attempt + 1)
+		if delay < max/2 || delay > max {
+			t.Error("unexpected result:", attempt+1, delay)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Correlation-ID", r.Header.Get("X-Correlation-ID"))
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(srv, client.None)
+
+	order := []string{}
+	observed := 0
+
+	cli.Use(func(next client.Handler) client.Handler {
+		return func(rq *http.Request) (response.CouchResponse, error) {
+			order = append(order, "outer")
+			rq.Header.Set("X-Correlation-ID", "abc")
+			rs, err := next(rq)
+			if err == nil {
+				observed = rs.Code
+			}
+			return rs, err
+		}
+	}, func(next client.Handler) client.Handler {
+		return func(rq *http.Request) (response.CouchResponse, error) {
+			order = append(order, "inner")
+			return next(rq)
+		}
+	})
+
+	rq, _ := NewRequestBuilder().WithEndpoint("db").WithMethod(MethodGet).Build(cli)
+	rs, err := rq.Execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rs.Header.Get("X-Correlation-ID") != "abc" || observed != http.StatusOK {
+		t.Error("unexpected result")
+	}
+
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Error("unexpected result:", order)
+	}
+
+	fault := errors.New("injected fault")
+	cli.Use(func(next client.Handler) client.Handler {
+		return func(rq *http.Request) (response.CouchResponse, error) {
+			return response.CouchResponse{}, fault
+		}
+	})
+
+	if _, err = rq.Execute(context.Background()); err != fault {
+		t.Error("unexpected result:", err)
+	}
+}
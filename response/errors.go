@@ -0,0 +1,95 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+//RequestIDHeader - Header with an identifier of a request assigned by CouchDB
+const RequestIDHeader = "X-Couch-Request-ID"
+
+//Sentinel errors, a CouchError matches one of them with errors.Is according to its status code
+var (
+	ErrBadRequest         = errors.New("bad request")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForbidden          = errors.New("forbidden")
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+var sentinels = map[int]error{
+	StatusCode400BadRequest:         ErrBadRequest,
+	StatusCode401Unauthorized:       ErrUnauthorized,
+	StatusCode403Forbidden:          ErrForbidden,
+	StatusCode404NotFound:           ErrNotFound,
+	StatusCode409Conflict:           ErrConflict,
+	StatusCode412PreconditionFailed: ErrPreconditionFailed,
+}
+
+//CouchError - Error returned by CouchDB, contains the status code and the error and reason fields from the response body
+type CouchError struct {
+	StatusCode int    `json:"-"`
+	Err        string `json:"error"`
+	Reason     string `json:"reason"`
+	RequestID  string `json:"-"`
+	Endpoint   string `json:"-"`
+}
+
+func (e *CouchError) Error() string {
+
+	msg := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+
+	if e.Endpoint != "" {
+		msg = fmt.Sprintf("%s: %s", e.Endpoint, msg)
+	}
+
+	if e.Err != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+
+	if e.Reason != "" {
+		msg = fmt.Sprintf("%s, %s", msg, e.Reason)
+	}
+
+	return msg
+}
+
+//Is - Matches the error with a sentinel error of the same status code
+func (e *CouchError) Is(target error) bool {
+	sentinel, ok := sentinels[e.StatusCode]
+	return ok && sentinel == target
+}
+
+/*CheckStatus - Returns nil if a response has a successful status code, otherwise returns a CouchError built from the response.
+The body is read to get error details but it is replaced with a copy, so it still can be decoded.
+*/
+func CheckStatus(rs *CouchResponse, endpoint string) error {
+
+	if rs.CouchStatus == nil || rs.Code < StatusCode400BadRequest {
+		return nil
+	}
+
+	cerr := &CouchError{StatusCode: rs.Code, Endpoint: endpoint}
+
+	if rs.Header != nil {
+		cerr.RequestID = rs.Header.Get(RequestIDHeader)
+	}
+
+	if rs.Rdr == nil {
+		return cerr
+	}
+
+	data, _ := ioutil.ReadAll(rs.Rdr)
+	rs.Rdr.Close()
+	rs.Rdr = ioutil.NopCloser(bytes.NewReader(data))
+
+	//HEAD responses and some proxies return no body, so the details are optional
+	json.Unmarshal(data, cerr)
+
+	return cerr
+}
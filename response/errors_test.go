@@ -0,0 +1,55 @@
+package response
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckStatus(t *testing.T) {
+
+	rs := &CouchResponse{
+		CouchStatus: &CouchStatus{Code: 409, Status: "409 Conflict"},
+		Rdr:         ioutil.NopCloser(strings.NewReader(`{"error":"conflict","reason":"Document update conflict."}`)),
+		Header:      http.Header{},
+	}
+	rs.Header.Set(RequestIDHeader, "8a2c4ef1d7")
+
+	err := CheckStatus(rs, "db/doc")
+
+	if !errors.Is(err, ErrConflict) || errors.Is(err, ErrNotFound) {
+		t.Error("unexpected result:", err)
+	}
+
+	cerr := &CouchError{}
+	if !errors.As(err, &cerr) {
+		t.Fatal("unexpected result")
+	}
+
+	if cerr.StatusCode != 409 || cerr.Err != "conflict" || cerr.Reason != "Document update conflict." || cerr.RequestID != "8a2c4ef1d7" || cerr.Endpoint != "db/doc" {
+		t.Error("unexpected result:", cerr)
+	}
+
+	if err.Error() != "db/doc: 409 Conflict: conflict, Document update conflict." {
+		t.Error("unexpected result:", err.Error())
+	}
+
+	//body is still available
+	result := NewResult(rs.CouchStatus, rs.Rdr)
+	body := map[string]string{}
+	if err := result.Decode(&body); err != nil || body["error"] != "conflict" {
+		t.Error("unexpected result:", err)
+	}
+
+	rs = &CouchResponse{CouchStatus: &CouchStatus{Code: 404}, Rdr: ioutil.NopCloser(strings.NewReader(``))}
+	if err := CheckStatus(rs, "db"); !errors.Is(err, ErrNotFound) {
+		t.Error("unexpected result:", err)
+	}
+
+	rs = &CouchResponse{CouchStatus: &CouchStatus{Code: 201}}
+	if err := CheckStatus(rs, "db"); err != nil {
+		t.Error("unexpected result:", err)
+	}
+}
@@ -14,6 +14,7 @@ const (
 	StatusCode304NotModified        = 304
 	StatusCode400BadRequest         = 400
 	StatusCode401Unauthorized       = 401
+	StatusCode403Forbidden          = 403
 	StatusCode404NotFound           = 404
 	StatusCode409Conflict           = 409
 	StatusCode412PreconditionFailed = 412
@@ -48,6 +49,14 @@ func (r *CouchResult) Decode(v interface{}) error {
 	return json.Unmarshal(data, v)
 }
 
+//Close - Closes response body, it is useful when the body will not be decoded
+func (r *CouchResult) Close() error {
+	if r.rdr == nil {
+		return nil
+	}
+	return r.rdr.Close()
+}
+
 //CouchMultiResult - Conttains data returned in response. this struct is useful when a body contains
 //multiple objects like a result of a _find
 type CouchMultiResult struct {
@@ -62,9 +71,10 @@ func NewMultiResult(status *CouchStatus, crsr cursor.ResultCursor) *CouchMultiRe
 
 }
 
-//CouchResponse - Wraps CouchStatus and returned cookie
+//CouchResponse - Wraps CouchStatus, returned cookie and headers
 type CouchResponse struct {
 	*CouchStatus
 	Rdr    io.ReadCloser
 	Cookie http.Cookie
+	Header http.Header
 }
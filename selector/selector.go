@@ -0,0 +1,331 @@
+/*Package selector - Builds Mango selectors from Go values, so they do not have to be written as JSON strings.
+Every expression is validated when it is marshaled, e.g.
+
+	sel := selector.And(
+		selector.Gt("year", 1980),
+		selector.In(selector.Path("director", "name"), "Ridley Scott", "James Cameron"),
+	)
+
+An expression implements json.Marshaler and can be passed directly to CouchDatabase.Select
+*/
+package selector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//Operators of a selector
+const (
+	OpEq        = "$eq"
+	OpNe        = "$ne"
+	OpGt        = "$gt"
+	OpGte       = "$gte"
+	OpLt        = "$lt"
+	OpLte       = "$lte"
+	OpIn        = "$in"
+	OpNin       = "$nin"
+	OpAll       = "$all"
+	OpExists    = "$exists"
+	OpType      = "$type"
+	OpSize      = "$size"
+	OpMod       = "$mod"
+	OpRegex     = "$regex"
+	OpElemMatch = "$elemMatch"
+	OpAllMatch  = "$allMatch"
+	OpAnd       = "$and"
+	OpOr        = "$or"
+	OpNor       = "$nor"
+	OpNot       = "$not"
+)
+
+var (
+	//ErrEmptyField - a field name is empty outside of ElemMatch or AllMatch
+	ErrEmptyField = errors.New("field name cannot be empty")
+	//ErrArity - an operator received an invalid number of arguments
+	ErrArity = errors.New("invalid number of arguments")
+	//ErrInvalidArgument - an argument of an operator has an invalid type or value
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+var types = map[string]bool{"null": true, "boolean": true, "number": true, "string": true, "array": true, "object": true}
+
+//Expr - An expression of a selector
+type Expr interface {
+	json.Marshaler
+	//build - builds a value of the expression, nested is true inside of an operator that matches array elements
+	build(nested bool) (interface{}, error)
+}
+
+//Build - Validates and marshals an expression
+func Build(e Expr) (json.RawMessage, error) {
+	return marshal(e)
+}
+
+//Path - Joins segments of a path to a nested field, dots in segments are escaped
+func Path(segments ...string) string {
+
+	escaped := make([]string, len(segments))
+	for i, s := range segments {
+		escaped[i] = strings.ReplaceAll(s, ".", `\.`)
+	}
+
+	return strings.Join(escaped, ".")
+}
+
+func marshal(e Expr) ([]byte, error) {
+
+	if e == nil {
+		return nil, fmt.Errorf("%w: nil expression", ErrInvalidArgument)
+	}
+
+	v, err := e.build(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+//condition - an operator applied to a field
+type condition struct {
+	field string
+	op    string
+	value interface{}
+	err   error
+}
+
+func (c *condition) MarshalJSON() ([]byte, error) {
+	return marshal(c)
+}
+
+func (c *condition) build(nested bool) (interface{}, error) {
+
+	if c.err != nil {
+		return nil, fmt.Errorf("%s %s: %w", c.field, c.op, c.err)
+	}
+
+	value := c.value
+	if e, ok := value.(Expr); ok {
+		v, err := e.build(true)
+		if err != nil {
+			return nil, err
+		}
+		value = v
+	}
+
+	cond := map[string]interface{}{c.op: value}
+
+	if c.field == "" {
+		if !nested {
+			return nil, fmt.Errorf("%s: %w", c.op, ErrEmptyField)
+		}
+		return cond, nil
+	}
+
+	return map[string]interface{}{c.field: cond}, nil
+}
+
+//combination - a logical operator applied to a list of expressions
+type combination struct {
+	op    string
+	exprs []Expr
+}
+
+func (c *combination) MarshalJSON() ([]byte, error) {
+	return marshal(c)
+}
+
+func (c *combination) build(nested bool) (interface{}, error) {
+
+	if len(c.exprs) == 0 {
+		return nil, fmt.Errorf("%s: %w", c.op, ErrArity)
+	}
+
+	values := make([]interface{}, len(c.exprs))
+	for i, e := range c.exprs {
+		if e == nil {
+			return nil, fmt.Errorf("%s: %w: nil expression", c.op, ErrInvalidArgument)
+		}
+		v, err := e.build(nested)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	return map[string]interface{}{c.op: values}, nil
+}
+
+//negation - the $not operator
+type negation struct {
+	expr Expr
+}
+
+func (n *negation) MarshalJSON() ([]byte, error) {
+	return marshal(n)
+}
+
+func (n *negation) build(nested bool) (interface{}, error) {
+
+	if n.expr == nil {
+		return nil, fmt.Errorf("%s: %w: nil expression", OpNot, ErrInvalidArgument)
+	}
+
+	v, err := n.expr.build(nested)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{OpNot: v}, nil
+}
+
+func compare(field, op string, value interface{}) Expr {
+
+	if _, ok := value.(Expr); ok {
+		return &condition{field: field, op: op, err: ErrInvalidArgument}
+	}
+
+	return &condition{field: field, op: op, value: value}
+}
+
+func list(field, op string, values []interface{}) Expr {
+
+	if len(values) == 0 {
+		return &condition{field: field, op: op, err: ErrArity}
+	}
+
+	return &condition{field: field, op: op, value: values}
+}
+
+//Eq - Matches a field equal to the value
+func Eq(field string, value interface{}) Expr {
+	return compare(field, OpEq, value)
+}
+
+//Ne - Matches a field not equal to the value
+func Ne(field string, value interface{}) Expr {
+	return compare(field, OpNe, value)
+}
+
+//Gt - Matches a field greater than the value
+func Gt(field string, value interface{}) Expr {
+	return compare(field, OpGt, value)
+}
+
+//Gte - Matches a field greater than or equal to the value
+func Gte(field string, value interface{}) Expr {
+	return compare(field, OpGte, value)
+}
+
+//Lt - Matches a field less than the value
+func Lt(field string, value interface{}) Expr {
+	return compare(field, OpLt, value)
+}
+
+//Lte - Matches a field less than or equal to the value
+func Lte(field string, value interface{}) Expr {
+	return compare(field, OpLte, value)
+}
+
+//In - Matches a field equal to one of the values, at least one value is required
+func In(field string, values ...interface{}) Expr {
+	return list(field, OpIn, values)
+}
+
+//Nin - Matches a field not equal to any of the values, at least one value is required
+func Nin(field string, values ...interface{}) Expr {
+	return list(field, OpNin, values)
+}
+
+//All - Matches an array field that contains all of the values, at least one value is required
+func All(field string, values ...interface{}) Expr {
+	return list(field, OpAll, values)
+}
+
+//Exists - Matches documents that have or do not have the field
+func Exists(field string, exists bool) Expr {
+	return &condition{field: field, op: OpExists, value: exists}
+}
+
+//Type - Matches a field of the given type: null, boolean, number, string, array or object
+func Type(field, tp string) Expr {
+
+	if !types[tp] {
+		return &condition{field: field, op: OpType, err: ErrInvalidArgument}
+	}
+
+	return &condition{field: field, op: OpType, value: tp}
+}
+
+//Size - Matches an array field with the given length
+func Size(field string, size int) Expr {
+
+	if size < 0 {
+		return &condition{field: field, op: OpSize, err: ErrInvalidArgument}
+	}
+
+	return &condition{field: field, op: OpSize, value: size}
+}
+
+//Mod - Matches a field for which field % divisor == remainder, the divisor must be greater than zero
+func Mod(field string, divisor, remainder int) Expr {
+
+	if divisor <= 0 {
+		return &condition{field: field, op: OpMod, err: ErrInvalidArgument}
+	}
+
+	return &condition{field: field, op: OpMod, value: []int{divisor, remainder}}
+}
+
+//Regex - Matches a string field against a PCRE pattern, the pattern is evaluated by the server
+func Regex(field, pattern string) Expr {
+
+	if pattern == "" {
+		return &condition{field: field, op: OpRegex, err: ErrInvalidArgument}
+	}
+
+	return &condition{field: field, op: OpRegex, value: pattern}
+}
+
+//ElemMatch - Matches an array field with at least one element matching the expression, use an empty field name for the element itself
+func ElemMatch(field string, e Expr) Expr {
+
+	if e == nil {
+		return &condition{field: field, op: OpElemMatch, err: ErrInvalidArgument}
+	}
+
+	return &condition{field: field, op: OpElemMatch, value: e}
+}
+
+//AllMatch - Matches an array field with all elements matching the expression, use an empty field name for the element itself
+func AllMatch(field string, e Expr) Expr {
+
+	if e == nil {
+		return &condition{field: field, op: OpAllMatch, err: ErrInvalidArgument}
+	}
+
+	return &condition{field: field, op: OpAllMatch, value: e}
+}
+
+//And - Matches if all expressions match, at least one expression is required
+func And(exprs ...Expr) Expr {
+	return &combination{op: OpAnd, exprs: exprs}
+}
+
+//Or - Matches if any of expressions matches, at least one expression is required
+func Or(exprs ...Expr) Expr {
+	return &combination{op: OpOr, exprs: exprs}
+}
+
+//Nor - Matches if none of expressions matches, at least one expression is required
+func Nor(exprs ...Expr) Expr {
+	return &combination{op: OpNor, exprs: exprs}
+}
+
+//Not - Matches if the expression does not match
+func Not(e Expr) Expr {
+	return &negation{expr: e}
+}
@@ -0,0 +1,69 @@
+package selector
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestSelector(t *testing.T) {
+
+	tests := []struct {
+		expr     Expr
+		expected string
+	}{
+		{Eq("title", "Alien"), `{"title":{"$eq":"Alien"}}`},
+		{Gt(Path("director", "name"), "A"), `{"director.name":{"$gt":"A"}}`},
+		{Lte(Path("ratings", "imdb.com"), 8.5), `{"ratings.imdb\\.com":{"$lte":8.5}}`},
+		{In("year", 1979, 1986), `{"year":{"$in":[1979,1986]}}`},
+		{Exists("oscars", true), `{"oscars":{"$exists":true}}`},
+		{Regex("title", "^The"), `{"title":{"$regex":"^The"}}`},
+		{Mod("year", 2, 0), `{"year":{"$mod":[2,0]}}`},
+		{ElemMatch("genres", Eq("", "Horror")), `{"genres":{"$elemMatch":{"$eq":"Horror"}}}`},
+		{ElemMatch("cast", And(Eq("name", "Ripley"), Gt("age", 30))), `{"cast":{"$elemMatch":{"$and":[{"name":{"$eq":"Ripley"}},{"age":{"$gt":30}}]}}}`},
+		{Or(Eq("year", 1979), Not(Type("year", "number"))), `{"$or":[{"year":{"$eq":1979}},{"$not":{"year":{"$type":"number"}}}]}`},
+		{Nor(Size("genres", 0)), `{"$nor":[{"genres":{"$size":0}}]}`},
+	}
+
+	for _, tc := range tests {
+		data, err := json.Marshal(tc.expr)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != tc.expected {
+			t.Error("unexpected result:", string(data), "expected:", tc.expected)
+		}
+	}
+}
+
+func TestValidation(t *testing.T) {
+
+	tests := []struct {
+		expr Expr
+		err  error
+	}{
+		{Eq("", 1), ErrEmptyField},
+		{And(Eq("", 1)), ErrEmptyField},
+		{In("year"), ErrArity},
+		{And(), ErrArity},
+		{Or(Eq("year", 1), nil), ErrInvalidArgument},
+		{Not(nil), ErrInvalidArgument},
+		{Eq("year", Gt("year", 1)), ErrInvalidArgument},
+		{Type("year", "integer"), ErrInvalidArgument},
+		{Size("genres", -1), ErrInvalidArgument},
+		{Mod("year", 0, 1), ErrInvalidArgument},
+		{Regex("title", ""), ErrInvalidArgument},
+		{ElemMatch("genres", nil), ErrInvalidArgument},
+	}
+
+	for _, tc := range tests {
+		if _, err := Build(tc.expr); !errors.Is(err, tc.err) {
+			t.Error("unexpected result:", err, "expected:", tc.err)
+		}
+	}
+
+	if _, err := json.Marshal(In("year")); !errors.Is(err, ErrArity) {
+		t.Error("unexpected result:", err)
+	}
+}